@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cacheFile records, in each output directory, a hash of the inputs
+// of the last run for each package along with the files it wrote and
+// a hash of each:
+//
+//	<package> <hash> <file>:<hash>...
+const cacheFile = ".gengen.sum"
+
+// inputHash hashes everything that determines the output of a run: the
+// gengen binary, the flags, the replacement types and the contents of
+// the source files and license.
+func inputHash(sourceFiles []string, types map[string]string, license string) (string, error) {
+	h := sha256.New()
+
+	if exe, err := os.Executable(); err == nil {
+		if err := hashFile(h, exe); err != nil {
+			return "", err
+		}
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "force", "v", "summary", "cpuprofile":
+			// don't change what's written
+		default:
+			fmt.Fprintf(h, "-%s=%s\n", f.Name, f.Value)
+		}
+	})
+	fmt.Fprintf(h, "%q\n", types)
+
+	if license != "" {
+		if err := hashFile(h, license); err != nil {
+			return "", err
+		}
+	}
+	for _, file := range sourceFiles {
+		fmt.Fprintf(h, "%s\n", filepath.Base(file))
+		if err := hashFile(h, file); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// upToDate reports whether the last run for pkg into dir had the same
+// input hash and all of the files it wrote are still there, unchanged.
+func upToDate(dir, pkg, hash string) bool {
+	entries, err := readCache(dir)
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(entries[pkg])
+	if len(fields) == 0 || fields[0] != hash {
+		return false
+	}
+	for _, field := range fields[1:] {
+		i := strings.LastIndex(field, ":")
+		if i < 0 {
+			return false
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, field[:i]))
+		if err != nil || outputHash(src) != field[i+1:] {
+			return false
+		}
+	}
+	return true
+}
+
+func outputHash(src []byte) string {
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
+
+// updateCache records hash and the files written for pkg in dir, with
+// their hashes, keeping the entries for other packages.
+func updateCache(dir, pkg, hash string, files []outputFile) error {
+	entries, err := readCache(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	line := hash
+	for _, file := range files {
+		line += " " + file.name + ":" + outputHash(file.src)
+	}
+	entries[pkg] = line
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s\n", name, entries[name])
+	}
+	return ioutil.WriteFile(filepath.Join(dir, cacheFile), []byte(buf.String()), 0644)
+}
+
+// readCache returns the lines of dir's cache file keyed by package.
+func readCache(dir string) (map[string]string, error) {
+	entries := make(map[string]string)
+
+	f, err := os.Open(filepath.Join(dir, cacheFile))
+	if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.SplitN(s.Text(), " ", 2); len(fields) == 2 {
+			entries[fields[0]] = fields[1]
+		}
+	}
+	return entries, s.Err()
+}
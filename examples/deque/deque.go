@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+// Deque is a double-ended queue backed by a ring buffer.  The zero
+// value is an empty deque ready to use.
+type Deque struct {
+	buf   []generic.T
+	head  int
+	count int
+}
+
+func (d *Deque) Len() int {
+	return d.count
+}
+
+func (d *Deque) PushFront(v generic.T) {
+	d.grow()
+	d.head = (d.head - 1 + len(d.buf)) % len(d.buf)
+	d.buf[d.head] = v
+	d.count++
+}
+
+func (d *Deque) PushBack(v generic.T) {
+	d.grow()
+	d.buf[(d.head+d.count)%len(d.buf)] = v
+	d.count++
+}
+
+func (d *Deque) PopFront() (generic.T, bool) {
+	var zero generic.T
+	if d.count == 0 {
+		return zero, false
+	}
+
+	v := d.buf[d.head]
+	d.buf[d.head] = zero
+	d.head = (d.head + 1) % len(d.buf)
+	d.count--
+	return v, true
+}
+
+func (d *Deque) PopBack() (generic.T, bool) {
+	var zero generic.T
+	if d.count == 0 {
+		return zero, false
+	}
+
+	i := (d.head + d.count - 1) % len(d.buf)
+	v := d.buf[i]
+	d.buf[i] = zero
+	d.count--
+	return v, true
+}
+
+// grow doubles the buffer when it is full, unwrapping the elements so
+// that the front of the deque is at index 0.
+func (d *Deque) grow() {
+	if d.count < len(d.buf) {
+		return
+	}
+
+	n := len(d.buf) * 2
+	if n == 0 {
+		n = 4
+	}
+
+	buf := make([]generic.T, n)
+	for i := 0; i < d.count; i++ {
+		buf[i] = d.buf[(d.head+i)%len(d.buf)]
+	}
+	d.buf = buf
+	d.head = 0
+}
+
+func main() {
+	var d Deque
+	for i := 0; i < 5; i++ {
+		d.PushBack(i)
+		d.PushFront(-i)
+	}
+	fmt.Println(d.Len())
+
+	for {
+		v, ok := d.PopFront()
+		if !ok {
+			break
+		}
+		fmt.Print(v, " ")
+
+		if v, ok := d.PopBack(); ok {
+			fmt.Print(v, " ")
+		}
+	}
+	fmt.Println()
+}
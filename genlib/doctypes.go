@@ -0,0 +1,128 @@
+package genlib
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// docTypes returns the generic types with a replacement that each type
+// f declares with a doc comment uses, keyed by type name.
+func docTypes(f *ast.File, names map[string]bool, dotted map[*ast.Ident]bool, lookup map[string]string) map[string][]string {
+	docs := make(map[string][]string)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if typeDoc(gd, ts) == nil {
+				continue
+			}
+
+			seen := make(map[string]bool)
+			ast.Inspect(ts.Type, func(node ast.Node) bool {
+				var alias string
+				switch n := node.(type) {
+				case *ast.Ident:
+					if dotted[n] {
+						alias = n.Name
+					}
+				case *ast.SelectorExpr:
+					if x, ok := n.X.(*ast.Ident); ok && names[x.Name] {
+						alias = n.Sel.Name
+					}
+				}
+				if _, ok := lookup[alias]; ok && !seen[alias] {
+					seen[alias] = true
+					docs[ts.Name.Name] = append(docs[ts.Name.Name], alias)
+				}
+				return true
+			})
+		}
+	}
+	return docs
+}
+
+// addDocTypes ends the doc comments of the types in docs with a
+// paragraph naming the replacements for the generic types they use,
+// which the file calls pkg.T and so on, as in "In this version,
+// generic.T is int."  The lines are added as
+// they are, to be indented when src is formatted.
+func addDocTypes(src []byte, pkg string, docs map[string][]string, lookup map[string]string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	type insert struct {
+		offset int
+		text   string
+	}
+	var inserts []insert
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			doc := typeDoc(gd, ts)
+			aliases := docs[ts.Name.Name]
+			if doc == nil || len(aliases) == 0 {
+				continue
+			}
+
+			sort.Slice(aliases, func(i, j int) bool { return genericIndex(aliases[i]) < genericIndex(aliases[j]) })
+			var types []string
+			for _, alias := range aliases {
+				types = append(types, fmt.Sprintf("%s.%s is %s", pkg, alias, lookup[alias]))
+			}
+			note := "In this version, " + strings.Join(types, ", ") + "."
+
+			last := doc.List[len(doc.List)-1]
+			end := fset.Position(last.End()).Offset
+			if strings.HasPrefix(last.Text, "//") {
+				if last.Text != "//" {
+					note = "//\n// " + note
+				} else {
+					note = "// " + note
+				}
+				inserts = append(inserts, insert{end, "\n" + note})
+				continue
+			}
+
+			// inside a /* */ comment, before the closing */ and any
+			// space leading up to it
+			i := end - len("*/")
+			for i > 0 && (src[i-1] == ' ' || src[i-1] == '\t') {
+				i--
+			}
+			if src[i-1] == '\n' {
+				inserts = append(inserts, insert{i, "\n" + note + "\n"})
+			} else {
+				inserts = append(inserts, insert{i, "\n\n" + note + "\n"})
+			}
+		}
+	}
+
+	// insert from the end so earlier offsets stay valid
+	sort.Slice(inserts, func(i, j int) bool { return inserts[i].offset > inserts[j].offset })
+	for _, ins := range inserts {
+		src = append(src[:ins.offset], append([]byte(ins.text), src[ins.offset:]...)...)
+	}
+	return src, nil
+}
+
+// typeDoc returns the doc comment of ts, declared in gd.
+func typeDoc(gd *ast.GenDecl, ts *ast.TypeSpec) *ast.CommentGroup {
+	if ts.Doc == nil && len(gd.Specs) == 1 && !gd.Lparen.IsValid() {
+		return gd.Doc
+	}
+	return ts.Doc
+}
@@ -0,0 +1,226 @@
+package genlib
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// renameEmbedded renames references to the generic types embedded in
+// f's structs, which are named after their type, so that with T=Base
+// the field T of "struct{ *generic.T }" is referred to as Base.  The
+// generic package is the one f refers to by names, or through the
+// dot-imported dotted references.
+//
+// Without type information, a reference is only renamed where its
+// struct can be told from the syntax: keys of a literal of the struct
+// type, and selectors on a variable declared with it, or assigned a
+// literal of it, in f.  Others, like the results of function calls or
+// promoted fields, are left alone.
+func renameEmbedded(f *ast.File, names map[string]bool, dotted map[*ast.Ident]bool, lookup map[string]string) {
+	// fieldsOf returns the renames of the generic fields embedded in
+	// the struct typ is, or is declared in f as
+	fieldsOf := func(typ ast.Expr) map[string]string {
+		st := structType(typ)
+		if st == nil {
+			return nil
+		}
+		fields := make(map[string]string)
+		for _, field := range st.Fields.List {
+			if len(field.Names) > 0 {
+				continue
+			}
+			if alias := embeddedAlias(field.Type, names, dotted); alias != "" {
+				if typ, ok := lookup[alias]; ok {
+					if name := typeName(typ); name != "" && name != alias {
+						fields[alias] = name
+					}
+				}
+			}
+		}
+		return fields
+	}
+
+	ast.Inspect(f, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.CompositeLit:
+			fields := fieldsOf(n.Type)
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok && fields[key.Name] != "" {
+						key.Name = fields[key.Name]
+					}
+				}
+			}
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok {
+				if name := fieldsOf(varType(x))[n.Sel.Name]; name != "" {
+					n.Sel.Name = name
+				}
+			}
+		}
+		return true
+	})
+}
+
+// checkEmbedded returns an error if a generic type embedded in one of
+// f's structs has a replacement that can't be embedded, like []int, or
+// *[]byte for an embedded *generic.T.
+func checkEmbedded(fset *token.FileSet, f *ast.File, names map[string]bool, dotted map[*ast.Ident]bool, lookup map[string]string) error {
+	var err error
+	ast.Inspect(f, func(node ast.Node) bool {
+		st, ok := node.(*ast.StructType)
+		if !ok || err != nil {
+			return err == nil
+		}
+		for _, field := range st.Fields.List {
+			if len(field.Names) > 0 {
+				continue
+			}
+			alias := embeddedAlias(field.Type, names, dotted)
+			typ, ok := lookup[alias]
+			if alias == "" || !ok {
+				continue
+			}
+			// types that don't parse are reported, or kept as text,
+			// on their own
+			if _, perr := parseType(typ); perr != nil {
+				continue
+			}
+			_, pointer := field.Type.(*ast.StarExpr)
+			if !embeddable(typ, pointer) {
+				if pointer {
+					typ = "*" + typ
+				}
+				err = fmt.Errorf("%s: embedded field %s can't have type %s; only a type name or a pointer to one can be embedded",
+					fset.Position(field.Type.Pos()), alias, typ)
+				return false
+			}
+		}
+		return true
+	})
+	return err
+}
+
+// embeddable reports whether typ, or a pointer to it if pointer is
+// set, can be embedded in a struct.
+func embeddable(typ string, pointer bool) bool {
+	if typeName(typ) == "" {
+		return false
+	}
+	expr, _ := parseType(typ)
+	_, star := expr.(*ast.StarExpr)
+	return !(pointer && star)
+}
+
+// embeddedAlias returns the generic type an embedded field's type
+// refers to, or "" if it isn't one.
+func embeddedAlias(typ ast.Expr, names map[string]bool, dotted map[*ast.Ident]bool) string {
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if dotted[t] {
+			return t.Name
+		}
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok && names[x.Name] {
+			return t.Sel.Name
+		}
+	}
+	return ""
+}
+
+// structType returns the struct type typ is, possibly through a
+// pointer, an instantiation or the name of a type declared in the
+// same file, or nil.
+func structType(typ ast.Expr) *ast.StructType {
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		case *ast.ParenExpr:
+			typ = t.X
+		case *ast.StructType:
+			return t
+		case *ast.Ident:
+			if t.Obj == nil || t.Obj.Kind != ast.Typ {
+				return nil
+			}
+			ts, ok := t.Obj.Decl.(*ast.TypeSpec)
+			if !ok || ts.Assign.IsValid() {
+				return nil
+			}
+			typ = ts.Type
+		default:
+			return nil
+		}
+	}
+}
+
+// varType returns the type of the variable x refers to, as written in
+// its declaration or the composite literal assigned to it, or nil.
+func varType(x *ast.Ident) ast.Expr {
+	if x.Obj == nil || x.Obj.Kind != ast.Var {
+		return nil
+	}
+
+	var value ast.Expr
+	switch d := x.Obj.Decl.(type) {
+	case *ast.Field:
+		return d.Type
+	case *ast.ValueSpec:
+		if d.Type != nil {
+			return d.Type
+		}
+		for i, name := range d.Names {
+			if name.Obj == x.Obj && i < len(d.Values) && len(d.Names) == len(d.Values) {
+				value = d.Values[i]
+			}
+		}
+	case *ast.AssignStmt:
+		for i, lhs := range d.Lhs {
+			if id, ok := lhs.(*ast.Ident); ok && id.Obj == x.Obj && len(d.Lhs) == len(d.Rhs) {
+				value = d.Rhs[i]
+			}
+		}
+	}
+
+	if u, ok := value.(*ast.UnaryExpr); ok {
+		value = u.X
+	}
+	if lit, ok := value.(*ast.CompositeLit); ok {
+		return lit.Type
+	}
+	return nil
+}
+
+// typeName returns the name a type has as an embedded field, or ""
+// if it can't be embedded.
+func typeName(typ string) string {
+	expr, err := parseType(typ)
+	if err != nil {
+		return ""
+	}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch e := expr.(type) {
+	case *ast.IndexExpr:
+		expr = e.X
+	case *ast.IndexListExpr:
+		expr = e.X
+	}
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return ""
+}
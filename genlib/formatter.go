@@ -0,0 +1,47 @@
+package genlib
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runFormatter pipes src through an external formatting command.
+func runFormatter(name string, src []byte) ([]byte, error) {
+	path, err := findFormatter(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", name, msg)
+		}
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// findFormatter resolves a formatter command on PATH.  gofmt is also
+// looked for in the Go installation, where it always lives even when
+// it isn't on PATH.
+func findFormatter(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil || name != "gofmt" {
+		return path, err
+	}
+
+	out, goErr := exec.Command("go", "env", "GOROOT").Output()
+	if goErr != nil {
+		return "", err
+	}
+	return exec.LookPath(filepath.Join(strings.TrimSpace(string(out)), "bin", "gofmt"))
+}
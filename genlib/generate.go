@@ -2,12 +2,27 @@ package genlib
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
+	"go/printer"
 	"go/token"
+	"go/version"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
 )
 
 const pkgPath = "github.com/joeshaw/gengen/generic"
@@ -15,41 +30,942 @@ const genericPkg = "generic"
 
 var genericTypes = []string{"T", "U", "V"}
 
-func Generate(filename string, typenames ...string) ([]byte, error) {
+// Options controls how Generate converts a file.  The zero value
+// produces the same output as the package-level Generate function.
+type Options struct {
+	// OutputBuildTags guards the generated file with a build
+	// constraint requiring all of the given tags.  Any constraint
+	// already present in the source file is kept and combined with
+	// them.
+	OutputBuildTags []string
+
+	// TidyImports sorts, groups and dedupes the generated file's
+	// imports without adding or removing any.
+	TidyImports bool
+
+	// FixImports runs the generated file through goimports, adding
+	// missing imports and removing unused ones.  This is the same
+	// pass the gengen command applies by default, and implies
+	// TidyImports.
+	FixImports bool
+
+	// DocTypes adds a sentence naming the replacement types to the
+	// doc comment of each type declared with generic types, so that
+	// the doc of "type Cmp func(a, b generic.T) int" ends with "In
+	// this version, generic.T is int."
+	DocTypes bool
+
+	// KeepCommentsVerbatim leaves the template's comments exactly as
+	// they are written, whatever other options rewrite them, such as
+	// ReplaceInComments and DocTypes.  Comments gengen adds itself are
+	// still added.
+	KeepCommentsVerbatim bool
+
+	// EmitStringer appends a String method to the top-level type
+	// named by StringerType, formatting the value as its underlying
+	// (substituted) type.  It's intended for enum-like types defined
+	// as a generic type, e.g. "type Color generic.T" with T=int.
+	EmitStringer bool
+	StringerType string
+
+	// EmitInit is a text/template for the body of an init function
+	// appended to the generated file, so that specializations can
+	// register themselves.  It's executed with .Package, the name of
+	// the generated package, and .T, .U and .V, the replacement types:
+	//
+	//	registry.Register("{{.T}}", New)
+	//
+	// GeneratePackage only adds it to the package's first non-test file.
+	EmitInit string
+
+	// LicenseHeader is placed at the very top of the generated file,
+	// ahead of any build constraints and separated from the package
+	// doc comment by a blank line.  Text that isn't already a Go
+	// comment is turned into "//" line comments.
+	LicenseHeader string
+
+	// SubstitutionComment adds a comment block listing each generic
+	// type and its replacement to the top of the generated file, after
+	// any license header.
+	SubstitutionComment bool
+
+	// GoVersion is the oldest Go release, such as "go1.16", the
+	// generated code has to build with.  Code gengen adds itself,
+	// like String methods, only uses syntax from Go 1.0, so any
+	// release up to the one gengen was built with is accepted, but
+	// OutputBuildTags needs go1.17 or later: it writes //go:build
+	// lines, which older releases ignore.  EmitInit templates are up
+	// to the caller.
+	GoVersion string
+
+	// TypeOverrides replace generic types for the files of a
+	// GeneratePackage run whose base names match a pattern.  Each
+	// matching override is applied in order, so later ones take
+	// precedence.
+	TypeOverrides []TypeOverride
+
+	// Imports says which packages the package names in replacement
+	// types refer to.  Those the types use are imported explicitly,
+	// under their Alias if one is given.
+	Imports []Import
+
+	// TypeTagKeys are struct tag keys whose values name the field's
+	// type: "__TYPE__" in them is replaced with the field's type
+	// after substitution, so with TypeTagKeys{"schema"} and T=int
+	//
+	//	Value generic.T `schema:"type=__TYPE__"`
+	//
+	// becomes `schema:"type=int"`.
+	TypeTagKeys []string
+
+	// GenericPackage is the import path of the package defining the
+	// generic types, for templates using a copy of it.  Its types are
+	// recognized by the last element of the path, as in generic.T.
+	// It defaults to github.com/joeshaw/gengen/generic.
+	GenericPackage string
+
+	// PackageName, if set, replaces the name in the package clause.
+	// External test packages keep their "_test" suffix.
+	PackageName string
+
+	// KeepUnmapped leaves generic types that lookup has no
+	// replacement for as they are, with a warning for each, instead
+	// of failing.  It's for templates that are only partly
+	// specialized on purpose.
+	KeepUnmapped bool
+
+	// FailOnUnused makes a replacement type that's never used an
+	// error, since it's usually a typo.  Generate fails if the file
+	// doesn't use it, GeneratePackage only if none of the files do.
+	FailOnUnused bool
+
+	// ReplaceInTags replaces generic types written as {{T}}, {{U}}
+	// or {{V}} in struct tags, so `gen:"{{T}}"` becomes `gen:"int"`
+	// with T=int.
+	ReplaceInTags bool
+
+	// ReplaceInStrings replaces {{T}}, {{U}} and {{V}} in string
+	// literals, as in fmt.Errorf("expected {{T}}, got %T", x).  Raw
+	// and interpreted strings keep their quoting and escapes.
+	ReplaceInStrings bool
+
+	// ReplaceInComments also replaces generic types mentioned in
+	// comments, as in "Get returns the generic.U stored for k".
+	// It's off by default since comments may be about the generic
+	// package itself.
+	ReplaceInComments bool
+
+	// LineDirectives puts a "//line" directive giving the template's
+	// base name and line before each top-level declaration, so that
+	// compiler errors and stack traces point at the template rather
+	// than the generated file.
+	LineDirectives bool
+
+	// MaxLineLength, if positive, adds a warning for each line of the
+	// generated file longer than this many characters, tabs counting
+	// as one, so it's noticed when a long replacement type makes code
+	// unwieldy.
+	MaxLineLength int
+
+	// KeepUnformatted keeps going when the final formatting pass
+	// fails, typically because a replacement type produced invalid
+	// syntax, returning the file as it was before formatting with a
+	// warning instead of an error.
+	KeepUnformatted bool
+
+	// Formatter names an external command, such as "gofmt" or
+	// "goimports", that formats the generated file instead of the
+	// go/format gengen was built with, so the output matches the
+	// formatter a project pins.  It replaces the FixImports and
+	// TidyImports pass too; use goimports to keep fixing imports.  It
+	// is looked up on PATH, and in $(go env GOROOT)/bin for gofmt.
+	Formatter string
+}
+
+// Generate converts a file, replacing each generic type that's a key
+// of lookup, such as "T", with its value, such as "int".
+func Generate(filename string, lookup map[string]string) ([]byte, error) {
+	var o Options
+	return o.Generate(filename, lookup)
+}
+
+// GenerateArgs is like Generate, but takes the replacement types as
+// command line arguments, in any of the forms ParseArgs accepts.
+func GenerateArgs(filename string, args ...string) ([]byte, error) {
+	var o Options
+	return o.GenerateArgs(filename, args...)
+}
+
+func (o *Options) GenerateArgs(filename string, args ...string) ([]byte, error) {
+	lookup, err := ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return o.Generate(filename, lookup)
+}
+
+func (o *Options) Generate(filename string, lookup map[string]string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return o.GenerateReader(filename, f, lookup)
+}
+
+// GenerateReader is like Generate, but reads the source from src.  The
+// name is used in error positions and to tell goimports which file
+// the source belongs to.
+func GenerateReader(name string, src io.Reader, lookup map[string]string) ([]byte, error) {
+	var o Options
+	return o.GenerateReader(name, src, lookup)
+}
+
+func (o *Options) GenerateReader(name string, src io.Reader, lookup map[string]string) ([]byte, error) {
+	file, err := o.GenerateFile(name, src, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return file.Source, nil
+}
+
+// Replacement is a reference to a generic type in a source file, such
+// as generic.T, and the type it was replaced with.
+type Replacement struct {
+	Start, End token.Position // of the reference in the source file
+	Alias      string         // generic type, e.g. "T"
+	Type       string         // replacement type, e.g. "int"
+}
+
+// GenerateReplacements is like Generate, but also returns the
+// references to generic types that were replaced, in the order they
+// appear in filename, for tools showing what changed.
+func GenerateReplacements(filename string, lookup map[string]string) ([]byte, []Replacement, error) {
+	var o Options
+	return o.GenerateReplacements(filename, lookup)
+}
+
+func (o *Options) GenerateReplacements(filename string, lookup map[string]string) ([]byte, []Replacement, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	file, err := o.GenerateFile(filename, f, lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file.Source, file.Replacements, nil
+}
+
+// GenerateFile is like GenerateReader, but returns the converted File,
+// so its warnings and stats aren't lost.
+func GenerateFile(name string, src io.Reader, lookup map[string]string) (*File, error) {
+	var o Options
+	return o.GenerateFile(name, src, lookup)
+}
+
+func (o *Options) GenerateFile(name string, src io.Reader, lookup map[string]string) (*File, error) {
+	buf, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	f, err := parseSource(fset, name, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := o.convert(fset, name, f, lookup)
 	if err != nil {
 		return nil, err
 	}
+	if o.FailOnUnused && len(file.Stats.Unused) > 0 {
+		return nil, fmt.Errorf("%s: %s", name, UnusedError(lookup, file.Stats.Unused))
+	}
+	return file, nil
+}
+
+// TypeOverride gives different replacement types to some files of a
+// package, keyed by the generic type they replace:
+//
+//	TypeOverride{Pattern: "*_keyed.go", Types: map[string]string{"T": "string"}}
+type TypeOverride struct {
+	Pattern string // filepath.Match pattern for base file names
+	Types   map[string]string
+}
+
+// File is the result of converting one source file.
+type File struct {
+	Name   string // source file name
+	Source []byte // converted source
+	Stats  Stats
+
+	// Warnings are problems that didn't stop the file from being
+	// generated, but that the caller should report.
+	Warnings []string
+
+	// Replacements are the references to generic types that were
+	// replaced, in the order they appear in the source file.
+	Replacements []Replacement
+}
+
+// Stats describes what converting a file changed.
+type Stats struct {
+	Substitutions  int      // generic types replaced
+	ImportsAdded   []string // import paths not in the source file
+	ImportsRemoved []string // source file import paths no longer imported
+	Unused         []string // generic types with a replacement the file doesn't use
+}
+
+// GeneratePackage converts the files making up a package.  They are
+// parsed into a single FileSet up front, so a syntax error in any of
+// them is reported before anything is converted.  The results are in
+// the same order as filenames.
+func GeneratePackage(filenames []string, lookup map[string]string) ([]*File, error) {
+	var o Options
+	return o.GeneratePackage(filenames, lookup)
+}
+
+func (o *Options) GeneratePackage(filenames []string, lookup map[string]string) ([]*File, error) {
+	fset := token.NewFileSet()
+	parsed := make([]*ast.File, len(filenames))
+	for i, filename := range filenames {
+		f, err := parseFile(fset, filename)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = f
+	}
+
+	// the package should register itself only once
+	initFile := -1
+	for i, filename := range filenames {
+		if !strings.HasSuffix(filename, "_test.go") {
+			initFile = i
+			break
+		}
+	}
+
+	files := make([]*File, len(filenames))
+	for i, filename := range filenames {
+		fo := *o
+		if i != initFile {
+			fo.EmitInit = ""
+		}
+		types, err := o.fileTypes(filename, lookup)
+		if err != nil {
+			return nil, err
+		}
+		file, err := fo.convert(fset, filename, parsed[i], types)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = file
+	}
+
+	if unused := UnusedKeys(files); o.FailOnUnused && len(unused) > 0 {
+		return nil, UnusedError(lookup, unused)
+	}
+
+	return files, nil
+}
+
+// UnusedKeys returns the generic types with a replacement that none of
+// files use.
+func UnusedKeys(files []*File) []string {
+	if len(files) == 0 {
+		return nil
+	}
+
+	count := make(map[string]int)
+	for _, file := range files {
+		for _, alias := range file.Stats.Unused {
+			count[alias]++
+		}
+	}
+
+	var unused []string
+	for _, alias := range genericTypes {
+		if count[alias] == len(files) {
+			unused = append(unused, alias)
+		}
+	}
+	return unused
+}
+
+// UnusedError describes the unused replacements in lookup for the
+// generic types in unused, as in "substitution U=string was never
+// used".
+func UnusedError(lookup map[string]string, unused []string) error {
+	subs := make([]string, len(unused))
+	for i, alias := range unused {
+		subs[i] = alias + "=" + lookup[alias]
+	}
+	if len(subs) == 1 {
+		return fmt.Errorf("substitution %s was never used", subs[0])
+	}
+	return fmt.Errorf("substitutions %s were never used", strings.Join(subs, ", "))
+}
+
+// format runs the final formatting pass over a generated file.
+func (o *Options) format(filename string, src []byte) ([]byte, error) {
+	var err error
+	if o.Formatter != "" {
+		if src, err = runFormatter(o.Formatter, src); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		return src, nil
+	}
+
+	if o.FixImports || o.TidyImports {
+		src, err = imports.Process(filepath.Base(filename), src, &imports.Options{
+			TabWidth:   8,
+			TabIndent:  true,
+			Comments:   true,
+			Fragment:   true,
+			AllErrors:  false,
+			FormatOnly: !o.FixImports,
+		})
+	} else {
+		src, err = format.Source(src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return src, nil
+}
+
+// longLines returns a warning for each line of src longer than max.
+// The positions are in the generated file, so name is its base name.
+func longLines(name string, src []byte, max int) []string {
+	var warnings []string
+	for i, line := range strings.Split(string(src), "\n") {
+		if n := utf8.RuneCountInString(line); n > max {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: line is %d characters long, more than %d", name, i+1, n, max))
+		}
+	}
+	return warnings
+}
+
+// fileTypes returns lookup with the overrides for filename applied.
+func (o *Options) fileTypes(filename string, lookup map[string]string) (map[string]string, error) {
+	var types map[string]string
+	for _, ov := range o.TypeOverrides {
+		ok, err := filepath.Match(ov.Pattern, filepath.Base(filename))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", ov.Pattern, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if types == nil {
+			types = make(map[string]string, len(lookup))
+			for alias, typ := range lookup {
+				types[alias] = typ
+			}
+		}
+		for alias, typ := range ov.Types {
+			types[alias] = typ
+		}
+	}
+	if types == nil {
+		return lookup, nil
+	}
+	return types, nil
+}
+
+// convert substitutes the replacement types in lookup into the parsed
+// file f and returns the formatted result.
+func (o *Options) convert(fset *token.FileSet, filename string, f *ast.File, lookup map[string]string) (file *File, err error) {
+	// a bug in the walker shouldn't take down a whole batch run
+	defer func() {
+		if r := recover(); r != nil {
+			file = nil
+			err = fmt.Errorf("%s: internal error: %v (in %s)", filename, r, panicSite())
+		}
+	}()
+
+	if o.GoVersion != "" && !version.IsValid(o.GoVersion) {
+		return nil, fmt.Errorf("invalid Go version %q", o.GoVersion)
+	}
+	if o.GoVersion != "" && version.IsValid(runtime.Version()) && version.Compare(o.GoVersion, runtime.Version()) > 0 {
+		return nil, fmt.Errorf("Go version %s is newer than the %s gengen was built with", o.GoVersion, runtime.Version())
+	}
+	if o.GoVersion != "" && len(o.OutputBuildTags) > 0 && version.Compare(o.GoVersion, "go1.17") < 0 {
+		return nil, fmt.Errorf("OutputBuildTags writes //go:build lines, which need Go version go1.17 or later, not %s", o.GoVersion)
+	}
+
+	genericPath := o.genericPath()
+	names := genericNames(f, genericPath)
+	dotted := dotImported(f, genericPath)
+	// messages name the generic types as the file does
+	local := localName(names, genericPath)
+
+	for alias := range lookup {
+		if genericIndex(alias) < 0 {
+			return nil, fmt.Errorf("%s: %s.%s is not a generic type", filename, local, alias)
+		}
+	}
+
+	lookup, used, err := qualifyTypes(lookup, o.Imports)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	file = &File{Name: filename}
+
+	// replacements are spliced in as type expressions, so they have
+	// to parse as one; KeepUnformatted takes them as they are
+	for _, alias := range sortedKeys(lookup) {
+		if _, err := parseType(lookup[alias]); err != nil {
+			if !o.KeepUnformatted {
+				return nil, fmt.Errorf("%s: %s.%s: %s", filename, local, alias, err)
+			}
+			file.Warnings = append(file.Warnings, fmt.Sprintf("%s: %s.%s: %s; inserting it as text", filename, local, alias, err))
+		}
+	}
+
+	before := importPaths(f)
+
+	lines := declLines(fset, f)
+
+	if err := checkEmbedded(fset, f, names, dotted, lookup); err != nil {
+		return nil, err
+	}
+	renameEmbedded(f, names, dotted, lookup)
+
+	var docs map[string][]string
+	if o.DocTypes && !o.KeepCommentsVerbatim {
+		docs = docTypes(f, names, dotted, lookup)
+	}
+
+	// where each generic type without a replacement is first used
+	unmapped := make(map[string]string)
+	applied := make(map[string]bool)
+	// the nodes of spliced in replacements, which aren't walked
+	inserted := make(map[ast.Node]bool)
+	use := func(alias string, ref ast.Expr) ast.Node {
+		if typ, ok := lookup[alias]; ok {
+			file.Stats.Substitutions++
+			applied[alias] = true
+			file.Replacements = append(file.Replacements, Replacement{
+				Start: fset.Position(ref.Pos()),
+				End:   fset.Position(ref.End()),
+				Alias: alias,
+				Type:  typ,
+			})
+			expr := typeExpr(typ, ref.Pos())
+			ast.Inspect(expr, func(node ast.Node) bool {
+				inserted[node] = true
+				return true
+			})
+			return expr
+		}
+		if _, ok := unmapped[alias]; !ok && genericIndex(alias) >= 0 {
+			unmapped[alias] = fmt.Sprintf("%s: %s used but no substitution given", fset.Position(ref.Pos()), exprString(ref))
+		}
+		return ref
+	}
+
+	// struct tags and import paths aren't strings in the code's sense
+	notStrings := make(map[*ast.BasicLit]bool)
+	if o.ReplaceInStrings {
+		ast.Inspect(f, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.Field:
+				notStrings[n.Tag] = true
+			case *ast.ImportSpec:
+				notStrings[n.Path] = true
+			}
+			return true
+		})
+	}
 
 	f = replace(func(node ast.Node) ast.Node {
+		if inserted[node] {
+			return node
+		}
+
+		if lit, ok := node.(*ast.BasicLit); ok && lit.Kind == token.STRING && o.ReplaceInStrings && !notStrings[lit] {
+			for _, alias := range fillPlaceholders(lit, lookup) {
+				file.Stats.Substitutions++
+				applied[alias] = true
+			}
+			return node
+		}
+
+		if field, ok := node.(*ast.Field); ok && field.Tag != nil && o.ReplaceInTags {
+			for _, alias := range fillPlaceholders(field.Tag, lookup) {
+				file.Stats.Substitutions++
+				applied[alias] = true
+			}
+			return node
+		}
+
+		// bare T, U and V from a dot import
+		if id, ok := node.(*ast.Ident); ok && dotted[id] {
+			return use(id.Name, id)
+		}
+
 		se, ok := node.(*ast.SelectorExpr)
 		if !ok {
 			return node
 		}
 
 		x, ok := se.X.(*ast.Ident)
-		if !ok || x.Name != genericPkg {
+		if !ok || !names[x.Name] {
 			return node
 		}
 
-		for i, t := range genericTypes {
-			if se.Sel.Name == t {
-				return &ast.Ident{NamePos: 0, Name: typenames[i]}
-			}
+		return use(se.Sel.Name, se)
+	}, func(node ast.Node) {
+		// syntax newer than the walker is kept, but any generic
+		// types inside it are missed
+		file.Warnings = append(file.Warnings, fmt.Sprintf("%s: can't convert %T, leaving it as it is", fset.Position(node.Pos()), node))
+	}, f).(*ast.File)
+
+	if o.ReplaceInComments && !o.KeepCommentsVerbatim {
+		replaceInComments(f, names, lookup)
+	}
+
+	for _, alias := range genericTypes {
+		msg, ok := unmapped[alias]
+		if !ok {
+			continue
+		}
+		if !o.KeepUnmapped {
+			return nil, errors.New(msg)
+		}
+		file.Warnings = append(file.Warnings, msg)
+	}
+
+	for _, alias := range sortedKeys(lookup) {
+		if !applied[alias] {
+			file.Stats.Unused = append(file.Stats.Unused, alias)
 		}
+	}
 
-		return node
-	}, f).(*ast.File)
+	// the generic package has no side effects, so a blank import of
+	// it is dead too.  It has to go first, since UsesImport only looks
+	// at the first import of a path and treats a blank one as used.
+	for _, path := range genericImports(f, genericPath) {
+		astutil.DeleteNamedImport(fset, f, "_", path)
+		// UsesImport can't tell if a dot import is used, but every
+		// reference through it has been replaced unless one was
+		// left unmapped
+		if len(unmapped) == 0 {
+			astutil.DeleteNamedImport(fset, f, ".", path)
+		}
+		if !astutil.UsesImport(f, path) {
+			deleteImport(fset, f, path)
+		}
+	}
 
-	if !astutil.UsesImport(f, pkgPath) {
-		astutil.DeleteImport(fset, f, pkgPath)
+	for _, imp := range used {
+		astutil.AddNamedImport(fset, f, imp.Alias, imp.Path)
+	}
+
+	// goimports would find these too, but it doesn't always run
+	for _, path := range stdImports(f, lookup, applied, o.Imports) {
+		astutil.AddImport(fset, f, path)
+	}
+
+	if len(o.TypeTagKeys) > 0 {
+		if err := fillTypeTags(fset, f, o.TypeTagKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.PackageName != "" {
+		if strings.HasSuffix(f.Name.Name, "_test") {
+			f.Name.Name = o.PackageName + "_test"
+		} else {
+			f.Name.Name = o.PackageName
+		}
 	}
 
 	var buf bytes.Buffer
 	if err = format.Node(&buf, fset, f); err != nil {
+		if !o.KeepUnformatted {
+			return nil, err
+		}
+
+		// print without the checks format.Node makes, and skip
+		// everything else that would have to parse the result
+		buf.Reset()
+		if perr := printer.Fprint(&buf, fset, f); perr != nil {
+			return nil, err
+		}
+		file.Warnings = append(file.Warnings, fmt.Sprintf("%s: %s; keeping it unformatted and skipping further processing", filename, err))
+		file.Source = buf.Bytes()
+		return file, nil
+	}
+
+	src := buf.Bytes()
+	if len(docs) > 0 {
+		if src, err = addDocTypes(src, local, docs, lookup); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+
+	if o.EmitStringer {
+		if src, err = addStringer(src, o.StringerType); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+
+	if o.EmitInit != "" {
+		if src, err = addInit(src, f.Name.Name, o.EmitInit, lookup); err != nil {
+			return nil, fmt.Errorf("%s: init: %s", filename, err)
+		}
+	}
+
+	if len(o.OutputBuildTags) > 0 {
+		if src, err = addBuildTags(src, o.OutputBuildTags); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.SubstitutionComment {
+		src = addLicense(src, substitutionComment(local, lookup))
+	}
+
+	if o.LicenseHeader != "" {
+		src = addLicense(src, o.LicenseHeader)
+	}
+
+	formatted, err := o.format(filename, src)
+	if err != nil {
+		if !o.KeepUnformatted {
+			return nil, err
+		}
+		file.Warnings = append(file.Warnings, fmt.Sprintf("%s; keeping it unformatted", err))
+		formatted = src
+	}
+	src = formatted
+
+	if o.LineDirectives {
+		if src, err = addLineDirectives(src, filepath.Base(filename), lines); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	file.Source = src
+
+	if o.MaxLineLength > 0 {
+		file.Warnings = append(file.Warnings, longLines(filepath.Base(filename), src, o.MaxLineLength)...)
+	}
+
+	out, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.ImportsOnly)
+	if err != nil {
+		if o.KeepUnformatted {
+			return file, nil
+		}
 		return nil, err
 	}
+	after := importPaths(out)
+	file.Stats.ImportsAdded = missing(after, before)
+	file.Stats.ImportsRemoved = missing(before, after)
+
+	return file, nil
+}
+
+// genericPath returns the import path of the generic package.
+func (o *Options) genericPath() string {
+	if o.GenericPackage != "" {
+		return o.GenericPackage
+	}
+	return pkgPath
+}
+
+// replaceInComments replaces references to generic types in f's
+// comments, written as in code with one of names, with their
+// replacements from lookup.
+func replaceInComments(f *ast.File, names map[string]bool, lookup map[string]string) {
+	if len(names) == 0 || len(lookup) == 0 {
+		return
+	}
+
+	var pkgs, aliases []string
+	for name := range names {
+		pkgs = append(pkgs, regexp.QuoteMeta(name))
+	}
+	for alias := range lookup {
+		aliases = append(aliases, regexp.QuoteMeta(alias))
+	}
+	ref := regexp.MustCompile(`\b(` + strings.Join(pkgs, "|") + `)\.(` + strings.Join(aliases, "|") + `)\b`)
+
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			c.Text = ref.ReplaceAllStringFunc(c.Text, func(s string) string {
+				return lookup[s[strings.LastIndex(s, ".")+1:]]
+			})
+		}
+	}
+}
+
+// deleteImport removes the imports of path from f under any name, where
+// astutil.DeleteImport only removes those without one.
+func deleteImport(fset *token.FileSet, f *ast.File, path string) {
+	var names []string
+	for _, imp := range f.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err != nil || p != path {
+			continue
+		}
+		if imp.Name == nil {
+			names = append(names, "")
+		} else {
+			names = append(names, imp.Name.Name)
+		}
+	}
+	for _, name := range names {
+		astutil.DeleteNamedImport(fset, f, name, path)
+	}
+}
+
+// localName returns the name a file refers to the generic package at
+// genericPath by, given the names it imports it under, or the package's
+// own name if there's none.
+func localName(names map[string]bool, genericPath string) string {
+	var local string
+	for name := range names {
+		if local == "" || name < local {
+			local = name
+		}
+	}
+	if local == "" {
+		local = genericPath[strings.LastIndex(genericPath, "/")+1:]
+	}
+	return local
+}
+
+// genericNames returns the names f refers to the generic package at
+// genericPath by: those its imports of the package bind, as in
+// import g ".../generic" using g.T.  If f doesn't import it, as in a
+// fragment of a template, the last element of the path is assumed,
+// unless another of f's imports has that name.
+func genericNames(f *ast.File, genericPath string) map[string]bool {
+	paths := make(map[string]bool)
+	for _, path := range genericImports(f, genericPath) {
+		paths[path] = true
+	}
 
-	return format.Source(buf.Bytes())
+	names := make(map[string]bool)
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || !paths[path] {
+			continue
+		}
+		switch {
+		case imp.Name == nil:
+			names[path[strings.LastIndex(path, "/")+1:]] = true
+		case imp.Name.Name != "_" && imp.Name.Name != ".":
+			names[imp.Name.Name] = true
+		}
+	}
+	if len(paths) == 0 {
+		name := genericPath[strings.LastIndex(genericPath, "/")+1:]
+		for _, imp := range f.Imports {
+			if n, _ := importName(imp); n == name {
+				return names
+			}
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// dotImported returns the bare references to generic types in f, if it
+// dot imports the generic package at genericPath.  Only identifiers
+// the parser couldn't resolve within the file count, so a local T
+// shadowing generic.T is left alone.
+func dotImported(f *ast.File, genericPath string) map[*ast.Ident]bool {
+	paths := make(map[string]bool)
+	for _, path := range genericImports(f, genericPath) {
+		paths[path] = true
+	}
+
+	dot := false
+	for _, imp := range f.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err == nil && paths[path] && imp.Name != nil && imp.Name.Name == "." {
+			dot = true
+		}
+	}
+	if !dot {
+		return nil
+	}
+
+	refs := make(map[*ast.Ident]bool)
+	for _, id := range f.Unresolved {
+		if genericIndex(id.Name) >= 0 {
+			refs[id] = true
+		}
+	}
+	return refs
+}
+
+// exprString returns the source text of a reference to a generic type.
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
+
+// genericImports returns the paths f imports the generic package at
+// genericPath by.  Unless another path was chosen, any path ending in
+// "gengen/generic" is taken to be a copy of the canonical one, as in a
+// fork under another module path.
+func genericImports(f *ast.File, genericPath string) []string {
+	var paths []string
+	for path := range importPaths(f) {
+		if path == genericPath || genericPath == pkgPath && (path == "gengen/generic" || strings.HasSuffix(path, "/gengen/generic")) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// importPaths returns the set of paths f imports.
+func importPaths(f *ast.File) map[string]bool {
+	paths := make(map[string]bool, len(f.Imports))
+	for _, imp := range f.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+			paths[path] = true
+		}
+	}
+	return paths
+}
+
+// missing returns the sorted paths in a that aren't in b.
+func missing(a, b map[string]bool) []string {
+	var paths []string
+	for path := range a {
+		if !b[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// panicSite returns the function and line that raised the current
+// panic.  It must be called from a deferred function.
+func panicSite() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			return fmt.Sprintf("%s at %s:%d", f.Function, filepath.Base(f.File), f.Line)
+		}
+		if !more {
+			return "unknown location"
+		}
+	}
 }
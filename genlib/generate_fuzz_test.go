@@ -0,0 +1,48 @@
+package genlib
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// FuzzGenerate converts arbitrary source, seeded with the examples,
+// checking that it never panics and that what it generates is
+// formatted Go.
+func FuzzGenerate(f *testing.F) {
+	examples, err := filepath.Glob(filepath.Join("..", "examples", "*", "*.go"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, name := range examples {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src, "int")
+		f.Add(src, "*bytes.Buffer")
+	}
+
+	f.Fuzz(func(t *testing.T, src []byte, typ string) {
+		o := Options{KeepUnmapped: true}
+		file, err := o.GenerateFile("fuzz.go", bytes.NewReader(src), map[string]string{"T": typ, "U": "string"})
+		if err != nil {
+			// panics are recovered as internal errors
+			if strings.Contains(err.Error(), "internal error") {
+				t.Fatal(err)
+			}
+			return
+		}
+
+		formatted, err := format.Source(file.Source)
+		if err != nil {
+			t.Fatalf("generated source doesn't parse: %s\n%s", err, file.Source)
+		}
+		if !bytes.Equal(formatted, file.Source) {
+			t.Fatalf("generated source isn't formatted:\n%s", file.Source)
+		}
+	})
+}
@@ -0,0 +1,2800 @@
+package genlib
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// generateTests convert src with types and the options given, and
+// compare the result with want and the warnings with warnings, or the
+// error with err.
+var generateTests = []struct {
+	name     string
+	opts     Options
+	types    map[string]string
+	src      string
+	want     string   // converted source
+	warnings []string // warnings about it
+	err      string   // a substring of the error instead
+	check    bool     // type-check the converted source
+}{
+	{
+		name:  "method values and expressions",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Tree struct{ v generic.T }
+
+func (t *Tree) Get() generic.T { return t.v }
+
+func register(f func(*Tree) generic.T) {}
+
+func funcs(t *Tree) (func() generic.T, func(*Tree) generic.T) {
+	register((*Tree).Get)
+	return t.Get, (*Tree).Get
+}
+`,
+		want: `package p
+
+type Tree struct{ v int }
+
+func (t *Tree) Get() int { return t.v }
+
+func register(f func(*Tree) int) {}
+
+func funcs(t *Tree) (func() int, func(*Tree) int) {
+	register((*Tree).Get)
+	return t.Get, (*Tree).Get
+}
+`,
+		check: true,
+	},
+	{
+		name:  "anonymous struct types",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Tree struct{ keys []generic.T }
+
+func (t *Tree) Stats() struct {
+	Keys  generic.T
+	Depth int
+} {
+	return struct {
+		Keys  generic.T
+		Depth int
+	}{t.keys[0], 1}
+}
+
+func (t *Tree) Add(s struct{ Key generic.T }) {
+	t.keys = append(t.keys, s.Key)
+}
+`,
+		want: `package p
+
+type Tree struct{ keys []int }
+
+func (t *Tree) Stats() struct {
+	Keys  int
+	Depth int
+} {
+	return struct {
+		Keys  int
+		Depth int
+	}{t.keys[0], 1}
+}
+
+func (t *Tree) Add(s struct{ Key int }) {
+	t.keys = append(t.keys, s.Key)
+}
+`,
+		check: true,
+	},
+	{
+		name:  "typed iota constants",
+		types: map[string]string{"T": "uint8"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Color generic.T
+
+const (
+	Red generic.T = iota
+	Green
+	Blue
+)
+
+const (
+	Cyan Color = iota + 1
+	Magenta
+)
+`,
+		want: `package p
+
+type Color uint8
+
+const (
+	Red uint8 = iota
+	Green
+	Blue
+)
+
+const (
+	Cyan Color = iota + 1
+	Magenta
+)
+`,
+		check: true,
+	},
+	{
+		name:  "pointers to generic elements",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Node struct {
+	keys  []*generic.T
+	index map[*generic.T]generic.U
+}
+`,
+		want: `package p
+
+type Node struct {
+	keys  []*int
+	index map[*int]string
+}
+`,
+		check: true,
+	},
+	{
+		name:  "defer and go method calls",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Pool struct{}
+
+func (p *Pool) cleanup(v generic.T) {}
+
+func (p *Pool) Run(v int) {
+	defer p.cleanup(generic.T(v))
+	go p.cleanup(generic.T(v + 1))
+}
+`,
+		want: `package p
+
+type Pool struct{}
+
+func (p *Pool) cleanup(v int) {}
+
+func (p *Pool) Run(v int) {
+	defer p.cleanup(int(v))
+	go p.cleanup(int(v + 1))
+}
+`,
+		check: true,
+	},
+	{
+		name:  "header directives",
+		types: map[string]string{"T": "int"},
+		src: `// Copyright 2024 The Authors.
+
+//go:build linux
+
+// Package p is low level.
+package p
+
+//go:cgo_import_dynamic libc_getpid getpid "libc.so"
+
+import (
+	_ "unsafe"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+//go:linkname now runtime.nanotime
+func now() generic.T
+
+//go:noinline
+func twice(v generic.T) generic.T { return v * 2 }
+`,
+		want: `// Copyright 2024 The Authors.
+
+//go:build linux
+
+// Package p is low level.
+package p
+
+//go:cgo_import_dynamic libc_getpid getpid "libc.so"
+
+import (
+	_ "unsafe"
+)
+
+//go:linkname now runtime.nanotime
+func now() int
+
+//go:noinline
+func twice(v int) int { return v * 2 }
+`,
+	},
+	{
+		name:  "named func types",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Getter func() generic.T
+
+func constant(v generic.T) Getter {
+	return func() generic.T { return v }
+}
+
+func sum(gs ...Getter) int {
+	var n int
+	for _, g := range gs {
+		n += g()
+	}
+	return n
+}
+`,
+		want: `package p
+
+type Getter func() int
+
+func constant(v int) Getter {
+	return func() int { return v }
+}
+
+func sum(gs ...Getter) int {
+	var n int
+	for _, g := range gs {
+		n += g()
+	}
+	return n
+}
+`,
+		check: true,
+	},
+	{
+		name:  "inline anonymous struct literals",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Map struct{ m map[generic.T]generic.U }
+
+func (m *Map) All() []struct {
+	K generic.T
+	V generic.U
+} {
+	var out []struct {
+		K generic.T
+		V generic.U
+	}
+	for k, v := range m.m {
+		out = append(out, struct {
+			K generic.T
+			V generic.U
+		}{k, v})
+	}
+	return out
+}
+`,
+		want: `package p
+
+type Map struct{ m map[int]string }
+
+func (m *Map) All() []struct {
+	K int
+	V string
+} {
+	var out []struct {
+		K int
+		V string
+	}
+	for k, v := range m.m {
+		out = append(out, struct {
+			K int
+			V string
+		}{k, v})
+	}
+	return out
+}
+`,
+		check: true,
+	},
+	{
+		name:  "returned closures",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func MakeAdder(n generic.T) func(generic.T) generic.T {
+	return func(x generic.T) generic.T { return x + n }
+}
+`,
+		want: `package p
+
+func MakeAdder(n int) func(int) int {
+	return func(x int) int { return x + n }
+}
+`,
+		check: true,
+	},
+	{
+		name:  "fixed array replacements",
+		types: map[string]string{"T": "[4]byte"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+const N = 8
+
+type Set struct {
+	items []generic.T
+	index map[string]generic.T
+}
+`,
+		want: `package p
+
+const N = 8
+
+type Set struct {
+	items [][4]byte
+	index map[string][4]byte
+}
+`,
+		check: true,
+	},
+	{
+		name:  "array replacements with a constant length",
+		types: map[string]string{"T": "[N]byte"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+const N = 8
+
+type Set struct {
+	items []generic.T
+	index map[string]generic.T
+}
+`,
+		want: `package p
+
+const N = 8
+
+type Set struct {
+	items [][N]byte
+	index map[string][N]byte
+}
+`,
+		check: true,
+	},
+	{
+		name:  "array replacements without a length",
+		types: map[string]string{"T": "[...]int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+const N = 8
+
+type Set struct {
+	items []generic.T
+	index map[string]generic.T
+}
+`,
+		err: "array length is only allowed in composite literals",
+	},
+	{
+		name:  "replacements that aren't types",
+		types: map[string]string{"T": "[4]"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+const N = 8
+
+type Set struct {
+	items []generic.T
+	index map[string]generic.T
+}
+`,
+		err: "\"[4]\" is not a valid type",
+	},
+	{
+		name:  "comments",
+		opts:  Options{ReplaceInComments: true},
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+// Get returns the generic.U stored for k, or the zero generic.U.
+func Get(m map[generic.T]generic.U, k generic.T) generic.U {
+	return m[k] // a generic.U
+}
+`,
+		want: `package p
+
+// Get returns the string stored for k, or the zero string.
+func Get(m map[int]string, k int) string {
+	return m[k] // a string
+}
+`,
+	},
+	{
+		name:  "comments kept verbatim",
+		opts:  Options{ReplaceInComments: true, KeepCommentsVerbatim: true},
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+// Get returns the generic.U stored for k, or the zero generic.U.
+func Get(m map[generic.T]generic.U, k generic.T) generic.U {
+	return m[k] // a generic.U
+}
+`,
+		want: `package p
+
+// Get returns the generic.U stored for k, or the zero generic.U.
+func Get(m map[int]string, k int) string {
+	return m[k] // a generic.U
+}
+`,
+	},
+	{
+		name:  "min, max and clear",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func clamp(a, b generic.T) generic.T {
+	return min(max(a, generic.T(0)), b)
+}
+
+func reset(m map[generic.T]generic.U, s []generic.U) {
+	clear(m)
+	clear(s)
+}
+`,
+		want: `package p
+
+func clamp(a, b int) int {
+	return min(max(a, int(0)), b)
+}
+
+func reset(m map[int]string, s []string) {
+	clear(m)
+	clear(s)
+}
+`,
+		check: true,
+	},
+	{
+		name:  "labeled loops",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func find(rows [][]generic.T, v generic.T) (int, bool) {
+Outer:
+	for i, row := range rows {
+		for _, x := range row {
+			if x == generic.T(0) {
+				continue Outer
+			}
+			if x == v {
+				return i, true
+			}
+		}
+		if len(row) == 0 {
+			break Outer
+		}
+	}
+	return 0, false
+}
+`,
+		want: `package p
+
+func find(rows [][]int, v int) (int, bool) {
+Outer:
+	for i, row := range rows {
+		for _, x := range row {
+			if x == int(0) {
+				continue Outer
+			}
+			if x == v {
+				return i, true
+			}
+		}
+		if len(row) == 0 {
+			break Outer
+		}
+	}
+	return 0, false
+}
+`,
+		check: true,
+	},
+	{
+		name:  "type switch cases",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func kind(v any) string {
+	switch x := v.(type) {
+	case generic.T, generic.U:
+		return "generic"
+	case []generic.T:
+		return "slice"
+	default:
+		_ = x
+		var zero generic.T
+		_ = zero
+		return "other"
+	}
+}
+`,
+		want: `package p
+
+func kind(v any) string {
+	switch x := v.(type) {
+	case int, string:
+		return "generic"
+	case []int:
+		return "slice"
+	default:
+		_ = x
+		var zero int
+		_ = zero
+		return "other"
+	}
+}
+`,
+		check: true,
+	},
+	{
+		name:  "elided composite literal types",
+		types: map[string]string{"T": "float64"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Point struct{ X, Y generic.T }
+
+var points = []Point{{X: generic.T(1)}, {generic.T(2), generic.T(3)}}
+
+var byName = map[string][]Point{"origin": {{}}, "unit": {{X: generic.T(1), Y: generic.T(1)}}}
+`,
+		want: `package p
+
+type Point struct{ X, Y float64 }
+
+var points = []Point{{X: float64(1)}, {float64(2), float64(3)}}
+
+var byName = map[string][]Point{"origin": {{}}, "unit": {{X: float64(1), Y: float64(1)}}}
+`,
+		check: true,
+	},
+	{
+		name:  "range over func iterators",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Map struct{ m map[generic.T]generic.U }
+
+func (m *Map) All() func(yield func(generic.T, generic.U) bool) {
+	return func(yield func(generic.T, generic.U) bool) {
+		for k, v := range m.m {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (m *Map) Keys() []generic.T {
+	var keys []generic.T
+	for k := range m.All() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+`,
+		want: `package p
+
+type Map struct{ m map[int]string }
+
+func (m *Map) All() func(yield func(int, string) bool) {
+	return func(yield func(int, string) bool) {
+		for k, v := range m.m {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (m *Map) Keys() []int {
+	var keys []int
+	for k := range m.All() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+`,
+		check: true,
+	},
+	{
+		name:  "map indexing ternary",
+		types: map[string]string{"T": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func pick(cond bool, a, b generic.T) generic.T {
+	return map[bool]generic.T{true: a, false: b}[cond]
+}
+`,
+		want: `package p
+
+func pick(cond bool, a, b string) string {
+	return map[bool]string{true: a, false: b}[cond]
+}
+`,
+		check: true,
+	},
+	{
+		name:  "doc types",
+		opts:  Options{DocTypes: true},
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type (
+	// Cmp compares a and b. Return value is:
+	//
+	//	< 0 if a <  b
+	//	  0 if a == b
+	//	> 0 if a >  b
+	//
+	Cmp func(a, b generic.T) int
+
+	// Tree is a B+tree.
+	Tree struct {
+		cmp Cmp
+	}
+)
+
+// Pair is a key and its value.
+type Pair struct {
+	Value generic.U
+	Key   generic.T
+}
+
+/*
+Entry is a Pair in a list.
+*/
+type Entry struct {
+	Pair
+	next *Entry
+	key  generic.T
+}
+
+type Undocumented generic.T
+`,
+		want: `package p
+
+type (
+	// Cmp compares a and b. Return value is:
+	//
+	//	< 0 if a <  b
+	//	  0 if a == b
+	//	> 0 if a >  b
+	//
+	// In this version, generic.T is int.
+	Cmp func(a, b int) int
+
+	// Tree is a B+tree.
+	Tree struct {
+		cmp Cmp
+	}
+)
+
+// Pair is a key and its value.
+//
+// In this version, generic.T is int, generic.U is string.
+type Pair struct {
+	Value string
+	Key   int
+}
+
+/*
+Entry is a Pair in a list.
+
+In this version, generic.T is int.
+*/
+type Entry struct {
+	Pair
+	next *Entry
+	key  int
+}
+
+type Undocumented int
+`,
+	},
+	{
+		name:  "doc types kept verbatim",
+		opts:  Options{DocTypes: true, KeepCommentsVerbatim: true},
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type (
+	// Cmp compares a and b. Return value is:
+	//
+	//	< 0 if a <  b
+	//	  0 if a == b
+	//	> 0 if a >  b
+	//
+	Cmp func(a, b generic.T) int
+
+	// Tree is a B+tree.
+	Tree struct {
+		cmp Cmp
+	}
+)
+
+// Pair is a key and its value.
+type Pair struct {
+	Value generic.U
+	Key   generic.T
+}
+
+/*
+Entry is a Pair in a list.
+*/
+type Entry struct {
+	Pair
+	next *Entry
+	key  generic.T
+}
+
+type Undocumented generic.T
+`,
+		want: `package p
+
+type (
+	// Cmp compares a and b. Return value is:
+	//
+	//	< 0 if a <  b
+	//	  0 if a == b
+	//	> 0 if a >  b
+	//
+	Cmp func(a, b int) int
+
+	// Tree is a B+tree.
+	Tree struct {
+		cmp Cmp
+	}
+)
+
+// Pair is a key and its value.
+type Pair struct {
+	Value string
+	Key   int
+}
+
+/*
+Entry is a Pair in a list.
+*/
+type Entry struct {
+	Pair
+	next *Entry
+	key  int
+}
+
+type Undocumented int
+`,
+	},
+	{
+		name:  "type aliases",
+		types: map[string]string{"T": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type key = generic.T
+
+type index map[key]int
+
+func (ix index) add(k key) { ix[k] = len(ix) }
+
+func lookup(ix index, k string) int { return ix[k] }
+`,
+		want: `package p
+
+type key = string
+
+type index map[key]int
+
+func (ix index) add(k key) { ix[k] = len(ix) }
+
+func lookup(ix index, k string) int { return ix[k] }
+`,
+		check: true,
+	},
+	{
+		name:  "unsafe pointer conversions",
+		types: map[string]string{"T": "uint64"},
+		src: `package p
+
+import (
+	"unsafe"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+func load(p unsafe.Pointer) generic.T {
+	return *(*generic.T)(unsafe.Pointer(p))
+}
+`,
+		want: `package p
+
+import (
+	"unsafe"
+)
+
+func load(p unsafe.Pointer) uint64 {
+	return *(*uint64)(unsafe.Pointer(p))
+}
+`,
+		check: true,
+	},
+	{
+		name:  "array map keys",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Grid struct {
+	cells map[[2]generic.T]generic.U
+	rows  [][3]generic.T
+}
+
+func (g *Grid) Get(x, y generic.T) generic.U {
+	return g.cells[[2]generic.T{x, y}]
+}
+
+func copyRow(src []generic.T) []generic.T {
+	dst := make([]generic.T, len(src))
+	copy(dst, src)
+	return dst
+}
+`,
+		want: `package p
+
+type Grid struct {
+	cells map[[2]int]string
+	rows  [][3]int
+}
+
+func (g *Grid) Get(x, y int) string {
+	return g.cells[[2]int{x, y}]
+}
+
+func copyRow(src []int) []int {
+	dst := make([]int, len(src))
+	copy(dst, src)
+	return dst
+}
+`,
+		check: true,
+	},
+	{
+		name:  "conversions in fmt calls",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import (
+	"fmt"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+func describe(x int) string {
+	return fmt.Sprintf("%v", generic.T(x))
+}
+
+func check(x int) error {
+	if x < 0 {
+		return fmt.Errorf("negative %T: %v", generic.T(x), generic.T(x))
+	}
+	return nil
+}
+`,
+		want: `package p
+
+import (
+	"fmt"
+)
+
+func describe(x int) string {
+	return fmt.Sprintf("%v", int(x))
+}
+
+func check(x int) error {
+	if x < 0 {
+		return fmt.Errorf("negative %T: %v", int(x), int(x))
+	}
+	return nil
+}
+`,
+		check: true,
+	},
+	{
+		name:  "recursive types",
+		types: map[string]string{"T": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Tree struct {
+	left, right *Tree
+	val         generic.T
+}
+
+func (t *Tree) Insert(v generic.T) *Tree {
+	if t == nil {
+		return &Tree{val: v}
+	}
+	if v < t.val {
+		t.left = t.left.Insert(v)
+	} else {
+		t.right = t.right.Insert(v)
+	}
+	return t
+}
+`,
+		want: `package p
+
+type Tree struct {
+	left, right *Tree
+	val         string
+}
+
+func (t *Tree) Insert(v string) *Tree {
+	if t == nil {
+		return &Tree{val: v}
+	}
+	if v < t.val {
+		t.left = t.left.Insert(v)
+	} else {
+		t.right = t.right.Insert(v)
+	}
+	return t
+}
+`,
+		check: true,
+	},
+	{
+		name:  "interface replacements",
+		opts:  Options{FixImports: true},
+		types: map[string]string{"T": "io.Reader"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Set map[generic.T]bool
+
+func (s Set) Has(v generic.T) bool {
+	for x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+`,
+		want: `package p
+
+import "io"
+
+type Set map[io.Reader]bool
+
+func (s Set) Has(v io.Reader) bool {
+	for x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+`,
+		check: true,
+	},
+	{
+		name:  "select statements",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func merge(ts <-chan generic.T, us chan generic.U, done chan struct{}) []any {
+	var out []any
+	for {
+		select {
+		case t := <-ts:
+			out = append(out, generic.T(t))
+		case us <- generic.U(""):
+		case v, ok := <-(chan any)(nil):
+			if ok {
+				out = append(out, v.(generic.T))
+			}
+		case <-done:
+			return out
+		}
+	}
+}
+`,
+		want: `package p
+
+func merge(ts <-chan int, us chan string, done chan struct{}) []any {
+	var out []any
+	for {
+		select {
+		case t := <-ts:
+			out = append(out, int(t))
+		case us <- string(""):
+		case v, ok := <-(chan any)(nil):
+			if ok {
+				out = append(out, v.(int))
+			}
+		case <-done:
+			return out
+		}
+	}
+}
+`,
+		check: true,
+	},
+	{
+		name:  "doc comments keep the declared name",
+		opts:  Options{ReplaceInComments: true},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+// Tree is a B+tree of generic.T keys.
+type Tree struct{ keys []generic.T }
+
+// Get returns the generic.T at i.
+func (t *Tree) Get(i int) generic.T { return t.keys[i] }
+`,
+		want: `package p
+
+// Tree is a B+tree of int keys.
+type Tree struct{ keys []int }
+
+// Get returns the int at i.
+func (t *Tree) Get(i int) int { return t.keys[i] }
+`,
+	},
+	{
+		name:  "multi-name declarations",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+var a, b generic.T = 1, 2
+
+var (
+	c, d generic.T
+	e, f = generic.T(3), generic.T(4)
+)
+
+func sum(x, y generic.T) generic.T {
+	var s, t generic.T = x, y
+	return s + t + c + d + e + f + a + b
+}
+`,
+		want: `package p
+
+var a, b int = 1, 2
+
+var (
+	c, d int
+	e, f = int(3), int(4)
+)
+
+func sum(x, y int) int {
+	var s, t int = x, y
+	return s + t + c + d + e + f + a + b
+}
+`,
+		check: true,
+	},
+	{
+		name:  "conversions in index positions",
+		types: map[string]string{"T": "int", "U": "uint8"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func at(arr []generic.T, i generic.U) generic.T {
+	return arr[int(generic.U(i))]
+}
+
+func window(arr []generic.T, lo, hi generic.U) []generic.T {
+	return arr[generic.T(lo):generic.T(hi)]
+}
+`,
+		want: `package p
+
+func at(arr []int, i uint8) int {
+	return arr[int(uint8(i))]
+}
+
+func window(arr []int, lo, hi uint8) []int {
+	return arr[int(lo):int(hi)]
+}
+`,
+		check: true,
+	},
+	{
+		name:  "deferred closures",
+		types: map[string]string{"T": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Pool struct{ free []generic.T }
+
+func (p *Pool) Put(x generic.T) { p.free = append(p.free, x) }
+
+func (p *Pool) Use(x generic.T, f func(generic.T)) {
+	defer func() {
+		recover()
+		p.Put(generic.T(x))
+	}()
+	defer func(v generic.T) { _ = v }(x)
+	f(x)
+}
+`,
+		want: `package p
+
+type Pool struct{ free []string }
+
+func (p *Pool) Put(x string) { p.free = append(p.free, x) }
+
+func (p *Pool) Use(x string, f func(string)) {
+	defer func() {
+		recover()
+		p.Put(string(x))
+	}()
+	defer func(v string) { _ = v }(x)
+	f(x)
+}
+`,
+		check: true,
+	},
+	{
+		name:  "package doc comments",
+		types: map[string]string{"T": "int"},
+		src: `// Package p is a list of generic.T values.
+//
+// Build one with gengen, giving the type for generic.T.
+package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type List []generic.T
+`,
+		want: `// Package p is a list of generic.T values.
+//
+// Build one with gengen, giving the type for generic.T.
+package p
+
+type List []int
+`,
+	},
+	{
+		name:  "package doc comments with comment replacement",
+		opts:  Options{ReplaceInComments: true},
+		types: map[string]string{"T": "int"},
+		src: `// Package p is a list of generic.T values.
+//
+// Build one with gengen, giving the type for generic.T.
+package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type List []generic.T
+`,
+		want: `// Package p is a list of int values.
+//
+// Build one with gengen, giving the type for int.
+package p
+
+type List []int
+`,
+	},
+	{
+		name:  "package doc comments kept verbatim",
+		opts:  Options{ReplaceInComments: true, KeepCommentsVerbatim: true},
+		types: map[string]string{"T": "int"},
+		src: `// Package p is a list of generic.T values.
+//
+// Build one with gengen, giving the type for generic.T.
+package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type List []generic.T
+`,
+		want: `// Package p is a list of generic.T values.
+//
+// Build one with gengen, giving the type for generic.T.
+package p
+
+type List []int
+`,
+	},
+	{
+		name:  "value and pointer receivers",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Stack struct{ items []generic.T }
+
+func (s Stack) Peek() generic.T { return s.items[len(s.items)-1] }
+
+func (s *Stack) Push(v generic.T) { s.items = append(s.items, v) }
+
+var (
+	peek = Stack.Peek
+	push = (*Stack).Push
+)
+`,
+		want: `package p
+
+type Stack struct{ items []int }
+
+func (s Stack) Peek() int { return s.items[len(s.items)-1] }
+
+func (s *Stack) Push(v int) { s.items = append(s.items, v) }
+
+var (
+	peek = Stack.Peek
+	push = (*Stack).Push
+)
+`,
+		check: true,
+	},
+	{
+		name:  "conversions in constants",
+		types: map[string]string{"T": "int32"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+const Max = generic.T(1<<31 - 1)
+
+const (
+	Min  = -Max - 1
+	Zero generic.T = 0
+)
+`,
+		want: `package p
+
+const Max = int32(1<<31 - 1)
+
+const (
+	Min        = -Max - 1
+	Zero int32 = 0
+)
+`,
+		check: true,
+	},
+	{
+		name:  "method expressions of instantiated types",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Tree[K comparable] struct{ m map[K]generic.U }
+
+func (t *Tree[K]) Set(k K, v generic.U) { t.m[k] = v }
+
+func register(f func(*Tree[generic.T], generic.T, generic.U)) {}
+
+func init() {
+	register((*Tree[generic.T]).Set)
+}
+`,
+		want: `package p
+
+type Tree[K comparable] struct{ m map[K]string }
+
+func (t *Tree[K]) Set(k K, v string) { t.m[k] = v }
+
+func register(f func(*Tree[int], int, string)) {}
+
+func init() {
+	register((*Tree[int]).Set)
+}
+`,
+		check: true,
+	},
+	{
+		name:  "blank generic import",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import (
+	"fmt"
+
+	_ "github.com/joeshaw/gengen/generic"
+)
+
+func Print(v int) { fmt.Println(v) }
+`,
+		want: `package p
+
+import (
+	"fmt"
+)
+
+func Print(v int) { fmt.Println(v) }
+`,
+	},
+	{
+		name:  "blank and named generic imports",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import (
+	_ "github.com/joeshaw/gengen/generic"
+	"github.com/joeshaw/gengen/generic"
+)
+
+type Box struct{ v generic.T }
+
+func (b Box) Get() generic.T {
+	return b.v
+}
+`,
+		want: `package p
+
+type Box struct{ v int }
+
+func (b Box) Get() int {
+	return b.v
+}
+`,
+		check: true,
+	},
+	{
+		name:  "generic package in a fork",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "example.com/fork/gengen/generic"
+
+type Box struct{ v generic.T }
+`,
+		want: `package p
+
+type Box struct{ v int }
+`,
+		check: true,
+	},
+	{
+		name:  "generic package in the template's module",
+		opts:  Options{GenericPackage: "example.com/mod/internal/generic"},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "example.com/mod/internal/generic"
+
+type Box struct{ v generic.T }
+`,
+		want: `package p
+
+type Box struct{ v int }
+`,
+		check: true,
+	},
+	{
+		name:  "other generic packages",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "example.com/other/generic"
+
+type Box struct{ v generic.T }
+`,
+		want: `package p
+
+import "example.com/other/generic"
+
+type Box struct{ v generic.T }
+`,
+	},
+	{
+		name:  "aliased imports for replacement types",
+		opts:  Options{Imports: []Import{{Name: "strings", Path: "example.com/x/strings", Alias: "xstrings"}}},
+		types: map[string]string{"T": "strings.Key"},
+		src: `package p
+
+import (
+	"strings"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+type Index map[generic.T]int
+
+func Upper(k generic.T) string { return strings.ToUpper(string(k)) }
+`,
+		want: `package p
+
+import (
+	xstrings "example.com/x/strings"
+	"strings"
+)
+
+type Index map[xstrings.Key]int
+
+func Upper(k xstrings.Key) string { return strings.ToUpper(string(k)) }
+`,
+	},
+	{
+		name:  "aliased generic import",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import g "github.com/joeshaw/gengen/generic"
+
+type Map map[g.T]g.U
+
+func (m Map) Get(k g.T) g.U { return m[k] }
+`,
+		want: `package p
+
+type Map map[int]string
+
+func (m Map) Get(k int) string { return m[k] }
+`,
+		check: true,
+	},
+	{
+		name:  "dot imported generic package",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import . "github.com/joeshaw/gengen/generic"
+
+type Map map[T]U
+
+func (m Map) Keys() []T {
+	var keys []T
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func first[T any](s []T) T { return s[0] }
+
+func local() {
+	type U struct{ n int }
+	_ = U{}
+}
+`,
+		want: `package p
+
+type Map map[int]string
+
+func (m Map) Keys() []int {
+	var keys []int
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func first[T any](s []T) T { return s[0] }
+
+func local() {
+	type U struct{ n int }
+	_ = U{}
+}
+`,
+		check: true,
+	},
+	{
+		name:  "interpreted strings",
+		opts:  Options{ReplaceInStrings: true},
+		types: map[string]string{"T": "int", "U": "map[string]string"},
+		src: `package p
+
+import (
+	"fmt"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+func check(x any) error {
+	if _, ok := x.(generic.T); !ok {
+		return fmt.Errorf("expected {{T}}\t(\"{{U}}\"), got %T; {{V}} stays", x)
+	}
+	return nil
+}
+`,
+		want: `package p
+
+import (
+	"fmt"
+)
+
+func check(x any) error {
+	if _, ok := x.(int); !ok {
+		return fmt.Errorf("expected int\t(\"map[string]string\"), got %T; {{V}} stays", x)
+	}
+	return nil
+}
+`,
+		check: true,
+	},
+	{
+		name:  "raw strings",
+		opts:  Options{ReplaceInStrings: true},
+		types: map[string]string{"T": "int", "U": "struct{ X int `json:\"x\"` }"},
+		src:   "package p\n\nconst (\n\tdoc   = `a list of {{T}}`\n\tquote = `holds {{U}}`\n\tplain = \"no {{ T }} here\"\n)\n",
+		// a raw string can't hold the backquotes of U
+		want: "package p\n\nconst (\n\tdoc   = `a list of int`\n\tquote = \"holds struct{ X int `json:\\\"x\\\"` }\"\n\tplain = \"no {{ T }} here\"\n)\n",
+	},
+	{
+		name:  "type parameters",
+		types: map[string]string{"T": "int", "U": "string"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type Number interface {
+	~int8 | ~float64 | generic.T
+}
+
+func Sum[N Number](ns ...N) N {
+	var s N
+	for _, n := range ns {
+		s += n
+	}
+	return s
+}
+
+func Keyed[K interface{ ~int | ~int8 }, V generic.U | []byte](k K, v V) Pair[K, V] {
+	return Pair[K, V]{k, v}
+}
+
+var pairs = []Pair[generic.T, generic.U]{{1, "one"}}
+
+func first() Pair[generic.T, generic.U] { return pairs[0] }
+
+var total = Sum[generic.T](1, 2)
+`,
+		want: `package p
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type Number interface {
+	~int8 | ~float64 | int
+}
+
+func Sum[N Number](ns ...N) N {
+	var s N
+	for _, n := range ns {
+		s += n
+	}
+	return s
+}
+
+func Keyed[K interface{ ~int | ~int8 }, V string | []byte](k K, v V) Pair[K, V] {
+	return Pair[K, V]{k, v}
+}
+
+var pairs = []Pair[int, string]{{1, "one"}}
+
+func first() Pair[int, string] { return pairs[0] }
+
+var total = Sum[int](1, 2)
+`,
+		check: true,
+	},
+	{
+		name:  "standard library replacement types",
+		types: map[string]string{"T": "time.Time", "U": "*bytes.Buffer", "V": "sql.NullString"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Record struct {
+	At   generic.T
+	Body generic.U
+	Note generic.V
+}
+`,
+		want: `package p
+
+import (
+	"bytes"
+	"database/sql"
+	"time"
+)
+
+type Record struct {
+	At   time.Time
+	Body *bytes.Buffer
+	Note sql.NullString
+}
+`,
+		check: true,
+	},
+	{
+		name:  "composite replacement types",
+		types: map[string]string{"T": "func() int", "U": "<-chan int", "V": "[]map[string]int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Sources struct {
+	next  generic.T
+	ch    generic.U
+	index generic.V
+}
+
+func (s *Sources) All() []generic.T { return []generic.T{s.next} }
+
+func (s *Sources) Recv() int { return <-s.ch }
+
+func lookup(v generic.V, k string) int { return v[0][k] }
+`,
+		want: `package p
+
+type Sources struct {
+	next  func() int
+	ch    <-chan int
+	index []map[string]int
+}
+
+func (s *Sources) All() []func() int { return []func() int{s.next} }
+
+func (s *Sources) Recv() int { return <-s.ch }
+
+func lookup(v []map[string]int, k string) int { return v[0][k] }
+`,
+		check: true,
+	},
+	{
+		name:  "embedded generic types",
+		types: map[string]string{"T": "Base"},
+		src: `package p
+
+import (
+	"testing"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+type Wrapper struct {
+	*generic.T
+	n int
+}
+
+func New(t *generic.T) *Wrapper {
+	return &Wrapper{T: t, n: 1}
+}
+
+func (w *Wrapper) Get() *generic.T { return w.T }
+
+func helper(t *testing.T, other struct{ T int }) {
+	var w Wrapper
+	v := &Wrapper{}
+	t.Log(w.T, v.T, other.T, struct{ T int }{T: 1})
+}
+`,
+		want: `package p
+
+import (
+	"testing"
+)
+
+type Wrapper struct {
+	*Base
+	n int
+}
+
+func New(t *Base) *Wrapper {
+	return &Wrapper{Base: t, n: 1}
+}
+
+func (w *Wrapper) Get() *Base { return w.Base }
+
+func helper(t *testing.T, other struct{ T int }) {
+	var w Wrapper
+	v := &Wrapper{}
+	t.Log(w.Base, v.Base, other.T, struct{ T int }{T: 1})
+}
+`,
+	},
+	{
+		name:  "String methods with fmt imported under another name",
+		opts:  Options{EmitStringer: true, StringerType: "Color"},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import (
+	f "fmt"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+type Color generic.T
+
+func show(c Color) string { return f.Sprint(int(c)) }
+`,
+		want: `package p
+
+import (
+	f "fmt"
+)
+
+type Color int
+
+func show(c Color) string { return f.Sprint(int(c)) }
+
+func (v Color) String() string {
+	return f.Sprintf("Color(%v)", int(v))
+}
+`,
+		check: true,
+	},
+	{
+		name:  "String methods with a local fmt",
+		opts:  Options{EmitStringer: true, StringerType: "Color"},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+var fmt = "%d"
+
+type Color generic.T
+`,
+		want: `package p
+
+import fmt2 "fmt"
+
+var fmt = "%d"
+
+type Color int
+
+func (v Color) String() string {
+	return fmt2.Sprintf("Color(%v)", int(v))
+}
+`,
+		check: true,
+	},
+	{
+		name:  "line directives after doc comments",
+		opts:  Options{LineDirectives: true, DocTypes: true},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+// List is a list.
+type List struct {
+	next *List
+	val  generic.T
+}
+
+// Len returns the length
+// of l.
+func (l *List) Len() int { return 0 }
+`,
+		want: `package p
+
+// List is a list.
+//
+// In this version, generic.T is int.
+//line p.go:6
+type List struct {
+	next *List
+	val  int
+}
+
+// Len returns the length
+// of l.
+//line p.go:13
+func (l *List) Len() int { return 0 }
+`,
+	},
+	{
+		name:  "placeholders in struct tags",
+		opts:  Options{ReplaceInTags: true},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Cell struct {
+	Value generic.T ` + "`gen:\"{{T}}\"`" + `
+	Name  string    ` + "`json:\"name\"`" + `
+}
+`,
+		want: `package p
+
+type Cell struct {
+	Value int    ` + "`gen:\"int\"`" + `
+	Name  string ` + "`json:\"name\"`" + `
+}
+`,
+		check: true,
+	},
+	{
+		name:  "embedded generic type replaced with a slice",
+		types: map[string]string{"T": "[]int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Wrapper struct {
+	generic.T
+}
+`,
+		err: "p.go:6:2: embedded field T can't have type []int",
+	},
+	{
+		name:  "embedded generic pointer replaced with a pointer",
+		types: map[string]string{"T": "*[]byte"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Wrapper struct {
+	*generic.T
+}
+`,
+		err: "p.go:6:2: embedded field T can't have type **[]byte",
+	},
+	{
+		name:  "embedded generic type replaced with a pointer",
+		types: map[string]string{"T": "*bytes.Buffer"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Wrapper struct {
+	generic.T
+}
+`,
+		want: `package p
+
+import "bytes"
+
+type Wrapper struct {
+	*bytes.Buffer
+}
+`,
+		check: true,
+	},
+	{
+		name:  "output build tags",
+		opts:  Options{OutputBuildTags: []string{"gengen_output"}},
+		types: map[string]string{"T": "int"},
+		src: `// Code generated by hand; DO NOT EDIT.
+
+//go:build linux
+// +build linux
+
+// Package p is a list.
+package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type List []generic.T
+`,
+		want: `//go:build linux && gengen_output
+
+// Code generated by hand; DO NOT EDIT.
+
+// Package p is a list.
+package p
+
+type List []int
+`,
+		check: true,
+	},
+	{
+		name:  "output build tags for an old Go version",
+		opts:  Options{OutputBuildTags: []string{"gengen_output"}, GoVersion: "go1.16"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n",
+		err:   "need Go version go1.17 or later",
+	},
+	{
+		name:  "tidy imports after the generic import goes",
+		opts:  Options{TidyImports: true},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import (
+	"sort"
+	"github.com/joeshaw/gengen/generic"
+	"fmt"
+	"fmt"
+)
+
+type List []generic.T
+
+func (l List) String() string { sort.Ints(l); return fmt.Sprint([]generic.T(l)) }
+`,
+		want: `package p
+
+import (
+	"fmt"
+	"sort"
+)
+
+type List []int
+
+func (l List) String() string { sort.Ints(l); return fmt.Sprint([]int(l)) }
+`,
+		check: true,
+	},
+	{
+		name:  "license header ahead of a build constraint",
+		opts:  Options{LicenseHeader: "Copyright 2024 The Authors.\nAll rights reserved.", OutputBuildTags: []string{"gengen_output"}},
+		types: map[string]string{"T": "int"},
+		src: `//go:build linux
+
+// Package p is a list.
+package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type List []generic.T
+`,
+		want: `// Copyright 2024 The Authors.
+// All rights reserved.
+
+//go:build linux && gengen_output
+
+// Package p is a list.
+package p
+
+type List []int
+`,
+	},
+	{
+		name:  "external formatter",
+		opts:  Options{Formatter: "gofmt"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List struct{ head generic.T; n int }\n",
+		want:  "package p\n\ntype List struct {\n\thead int\n\tn    int\n}\n",
+	},
+	{
+		name:  "missing external formatter",
+		opts:  Options{Formatter: "gengen-no-such-formatter"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n",
+		err:   "p.go: exec: \"gengen-no-such-formatter\": executable file not found",
+	},
+	{
+		name:  "failing external formatter",
+		opts:  Options{Formatter: "false"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n",
+		err:   "p.go: false: exit status 1",
+	},
+	{
+		name:  "init function",
+		opts:  Options{EmitInit: `registry[{{printf "%q" .Package}}+"/"+{{printf "%q" .T}}] = New{{if .U}} // U={{.U}}{{end}}`},
+		types: map[string]string{"T": "int"},
+		src: `package list
+
+import "github.com/joeshaw/gengen/generic"
+
+var registry = map[string]func() *List{}
+
+type List struct{ v generic.T }
+
+func New() *List { return &List{} }
+`,
+		want: `package list
+
+var registry = map[string]func() *List{}
+
+type List struct{ v int }
+
+func New() *List { return &List{} }
+
+func init() {
+	registry["list"+"/"+"int"] = New
+}
+`,
+		check: true,
+	},
+	{
+		name:  "init function with a bad template",
+		opts:  Options{EmitInit: "register({{.T}"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n",
+		err:   "p.go: init: template: init:1:",
+	},
+	{
+		name:  "invalid Go version",
+		opts:  Options{GoVersion: "1.16"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n",
+		err:   `invalid Go version "1.16"`,
+	},
+	{
+		name:  "Go version newer than gengen's",
+		opts:  Options{GoVersion: "go1.9999"},
+		types: map[string]string{"T": "int"},
+		src:   "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n",
+		err:   "Go version go1.9999 is newer than",
+	},
+	{
+		name:  "substitution comment",
+		opts:  Options{SubstitutionComment: true},
+		types: map[string]string{"V": "bool", "T": "int", "U": "map[string]int"},
+		src: `// Package p is a table.
+package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Row struct {
+	Key   generic.T
+	Value generic.U
+	Ok    generic.V
+}
+`,
+		want: `// Generated with these substitutions:
+//
+//	generic.T = int
+//	generic.U = map[string]int
+//	generic.V = bool
+
+// Package p is a table.
+package p
+
+type Row struct {
+	Key   int
+	Value map[string]int
+	Ok    bool
+}
+`,
+		check: true,
+	},
+	{
+		name:  "unformatted output kept",
+		opts:  Options{KeepUnformatted: true},
+		types: map[string]string{"T": "map[int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type List struct{ v generic.T }
+`,
+		want: `package p
+
+type List struct{ v map[int }
+`,
+		warnings: []string{
+			`p.go: generic.T: "map[int" is not a valid type: 1:8: expected ']', found newline; inserting it as text`,
+			`p.go: 3:29: expected ']', found '}'; keeping it unformatted`,
+		},
+	},
+	{
+		name:  "long lines",
+		opts:  Options{MaxLineLength: 40},
+		types: map[string]string{"T": "map[string][]*strings.Builder"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Index struct{ v generic.T }
+
+type Short struct{ n int }
+`,
+		want: `package p
+
+import "strings"
+
+type Index struct{ v map[string][]*strings.Builder }
+
+type Short struct{ n int }
+`,
+		warnings: []string{"p.go:5: line is 52 characters long, more than 40"},
+		check:    true,
+	},
+	{
+		name:  "field types in struct tags",
+		opts:  Options{TypeTagKeys: []string{"schema", "db"}},
+		types: map[string]string{"T": "[]int", "U": "time.Time"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Row struct {
+	Values generic.T ` + "`schema:\"type=__TYPE__\" json:\"__TYPE__\"`" + `
+	When   generic.U "db:\"__TYPE__,notnull\""
+	Count  int       ` + "`schema:\"type=__TYPE__\"`" + `
+	Name   string    ` + "`schema:\"name\"`" + `
+}
+`,
+		want: `package p
+
+import "time"
+
+type Row struct {
+	Values []int     ` + "`schema:\"type=[]int\" json:\"__TYPE__\"`" + `
+	When   time.Time "db:\"time.Time,notnull\""
+	Count  int       ` + "`schema:\"type=int\"`" + `
+	Name   string    ` + "`schema:\"name\"`" + `
+}
+`,
+		check: true,
+	},
+	{
+		name:  "field types in a malformed struct tag",
+		opts:  Options{TypeTagKeys: []string{"schema"}},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+type Row struct {
+	Value generic.T ` + "`schema:type=__TYPE__`" + `
+}
+`,
+		err: "p.go:6:18: struct tag \"schema:type=__TYPE__\" isn't in key:\"value\" form",
+	},
+	{
+		name:  "full slice expressions",
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import (
+	"unsafe"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+func head(s []byte) []byte {
+	return s[0:unsafe.Sizeof(generic.T(0)):unsafe.Sizeof(*new(generic.T))]
+}
+`,
+		want: `package p
+
+import (
+	"unsafe"
+)
+
+func head(s []byte) []byte {
+	return s[0:unsafe.Sizeof(int(0)):unsafe.Sizeof(*new(int))]
+}
+`,
+		check: true,
+	},
+	{
+		name:  "generic package imported under another name",
+		opts:  Options{SubstitutionComment: true},
+		types: map[string]string{"T": "int"},
+		src: `package p
+
+import g "github.com/joeshaw/gengen/generic"
+
+type List []g.T
+`,
+		want: `// Generated with these substitutions:
+//
+//	g.T = int
+
+package p
+
+type List []int
+`,
+	},
+	{
+		name:  "unknown generic type in a fork",
+		opts:  Options{GenericPackage: "example.com/fork/gen"},
+		types: map[string]string{"T": "int", "W": "string"},
+		src:   "package p\n\nimport \"example.com/fork/gen\"\n\ntype List []gen.T\n",
+		err:   "p.go: gen.W is not a generic type",
+	},
+	{
+		name:  "bad replacement for a generic package imported under another name",
+		types: map[string]string{"T": "map[int"},
+		src:   "package p\n\nimport g \"github.com/joeshaw/gengen/generic\"\n\ntype List []g.T\n",
+		err:   "p.go: g.T: \"map[int\" is not a valid type",
+	},
+}
+
+func TestGenerate(t *testing.T) {
+	for _, tt := range generateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := tt.opts.GenerateFile("p.go", strings.NewReader(tt.src), tt.types)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(file.Source); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if !reflect.DeepEqual(file.Warnings, tt.warnings) {
+				t.Errorf("got warnings %q, want %q", file.Warnings, tt.warnings)
+			}
+			if tt.check {
+				typeCheck(t, file.Source)
+			}
+		})
+	}
+}
+
+// typeCheck fails t if src doesn't compile.
+func typeCheck(t *testing.T, src []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("p", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("converted source doesn't compile: %s", err)
+	}
+}
+
+func TestReplacements(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "p.go")
+	src := `package p
+
+import "github.com/joeshaw/gengen/generic"
+
+func Swap(a generic.T, b generic.U) (generic.U, generic.T) { return b, a }
+`
+	if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, replacements, err := GenerateReplacements(name, map[string]string{"T": "int", "U": "string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"5:13-5:22 T=int",
+		"5:26-5:35 U=string",
+		"5:38-5:47 U=string",
+		"5:49-5:58 T=int",
+	}
+	var got []string
+	for _, r := range replacements {
+		got = append(got, fmt.Sprintf("%d:%d-%d:%d %s=%s", r.Start.Line, r.Start.Column, r.End.Line, r.End.Column, r.Alias, r.Type))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got replacements:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// A package that's only generic in its tests is converted file by
+// file: the test file loses its generic import, and the other file
+// comes out as it went in.
+func TestGenericOnlyInTests(t *testing.T) {
+	dir := filepath.Join("testdata", "testsonly")
+	names := []string{filepath.Join(dir, "set.go"), filepath.Join(dir, "set_test.go")}
+	files, err := GeneratePackage(names, map[string]string{"T": "int"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(files[0].Source); got != string(src) {
+		t.Errorf("set.go changed:\n%s", got)
+	}
+
+	want := `package set
+
+import (
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	s := make(Set)
+	var v int
+	s.Add(v)
+	if !s[v] {
+		t.Errorf("%v not added", v)
+	}
+}
+`
+	if got := string(files[1].Source); got != want {
+		t.Errorf("got set_test.go:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// The btree example, generated with int keys and string values, runs
+// with the main program written for it.
+func TestBtreeExample(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip(err)
+	}
+
+	example := filepath.Join("..", "examples", "btree")
+	// the example's main expects the tree in its own package
+	opts := Options{PackageName: "main"}
+	src, err := opts.Generate(filepath.Join(example, "btree.go"), map[string]string{"T": "int", "U": "string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainSrc, err := os.ReadFile(filepath.Join(example, "main", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"go.mod":   []byte("module btreetest\n"),
+		"btree.go": src,
+		"main.go":  mainSrc,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %s\n%s", err, out)
+	}
+	if got, want := string(out), "1 one\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+// Each file of a package gets its package clause from PackageName,
+// external tests keeping their _test suffix.
+func TestPackageName(t *testing.T) {
+	names := writeFiles(t, map[string]string{
+		"set.go":      "package set\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype Set map[generic.T]bool\n",
+		"set_test.go": "package set_test\n\nimport \"github.com/joeshaw/gengen/generic\"\n\nvar zero generic.T\n",
+	})
+
+	o := Options{PackageName: "intset"}
+	converted, err := o.GeneratePackage(names, map[string]string{"T": "int"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"set.go":      "package intset\n\ntype Set map[int]bool\n",
+		"set_test.go": "package intset_test\n\nvar zero int\n",
+	}
+	for _, file := range converted {
+		name := filepath.Base(file.Name)
+		if got := string(file.Source); got != want[name] {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want[name])
+		}
+	}
+}
+
+// writeFiles writes each file's source into a temporary directory and
+// returns their paths, sorted.
+func writeFiles(t *testing.T, files map[string]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var names []string
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.Join(dir, name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPackageNameFromTypes(t *testing.T) {
+	tests := []struct {
+		pkg    string
+		lookup map[string]string
+		want   string
+		err    string
+	}{
+		{pkg: "list", lookup: map[string]string{"T": "int"}, want: "intlist"},
+		{pkg: "list", lookup: map[string]string{"T": "*big.Int", "U": "string"}, want: "bigintlist"},
+		{pkg: "set", lookup: map[string]string{"T": "[4]byte"}, want: "byteset"},
+		{pkg: "tree", lookup: map[string]string{"U": "string"}, err: "no replacement for generic.T"},
+	}
+	for _, tt := range tests {
+		got, err := PackageName(tt.pkg, tt.lookup)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("PackageName(%q, %v): got error %v, want one containing %q", tt.pkg, tt.lookup, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("PackageName(%q, %v) = %q, %v, want %q", tt.pkg, tt.lookup, got, err, tt.want)
+		}
+	}
+}
+
+func TestPathological(t *testing.T) {
+	// too deeply nested to parse
+	src := "package p\n\nvar x = " + strings.Repeat("(", 100000) + "1" + strings.Repeat(")", 100000) + "\n"
+	if _, err := GenerateReader("p.go", strings.NewReader(src), map[string]string{"T": "int"}); err == nil {
+		t.Error("converting a too deeply nested expression succeeded")
+	}
+
+	// a syntax tree the parser wouldn't produce, with a function
+	// missing its type, makes the conversion panic
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\nfunc f(generic.T) {}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Decls[1].(*ast.FuncDecl).Type = nil
+
+	var o Options
+	_, err = o.convert(fset, "p.go", f, map[string]string{"T": "int"})
+	if err == nil || !strings.Contains(err.Error(), "p.go: internal error") {
+		t.Errorf("got error %v, want an internal error", err)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want map[string]string
+		err  string
+	}{
+		{args: []string{"int"}, want: map[string]string{"T": "int"}},
+		{args: []string{"int", "string"}, want: map[string]string{"T": "int", "U": "string"}},
+		{args: []string{"int", "U=string"}, want: map[string]string{"T": "int", "U": "string"}},
+		{args: []string{"U=string", "T=int"}, want: map[string]string{"T": "int", "U": "string"}},
+		{args: []string{"U=string", "int"}, want: map[string]string{"T": "int", "U": "string"}},
+		{args: []string{"int", "string", "T=bool"}, want: map[string]string{"T": "bool", "U": "string"}},
+		{args: []string{"V=[]byte", "int"}, want: map[string]string{"T": "int", "V": "[]byte"}},
+		{args: []string{"map[string]int"}, want: map[string]string{"T": "map[string]int"}},
+		{args: []string{"T=int", "T=string"}, err: "given more than once"},
+		{args: []string{"W=int"}, err: "not a generic type"},
+		{args: []string{"a", "b", "c", "d"}, err: "too many replacement types"},
+	}
+	for _, tt := range tests {
+		got, err := ParseArgs(tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseArgs(%q): got error %v, want one containing %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseArgs(%q): %s", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseArgs(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	src := `package p
+
+import (
+	"fmt"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+type Pair struct {
+	A, B generic.T
+	C    generic.U
+}
+
+func (p Pair) String() string { return fmt.Sprint(p.A) }
+`
+	var o Options
+	file, err := o.GenerateFile("p.go", strings.NewReader(src), map[string]string{"T": "time.Duration", "U": "int", "V": "bool"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		Substitutions:  2,
+		ImportsAdded:   []string{"time"},
+		ImportsRemoved: []string{"github.com/joeshaw/gengen/generic"},
+		Unused:         []string{"V"},
+	}
+	if !reflect.DeepEqual(file.Stats, want) {
+		t.Errorf("got %+v, want %+v", file.Stats, want)
+	}
+}
+
+// futureNode stands for syntax added to go/ast after replace was
+// written.
+type futureNode struct{ *ast.Ident }
+
+// replace leaves nodes it doesn't know alone and reports them.
+func TestReplaceUnknown(t *testing.T) {
+	node := &futureNode{ast.NewIdent("x")}
+	var unknown []ast.Node
+	got := replace(func(n ast.Node) ast.Node { return n }, func(n ast.Node) {
+		unknown = append(unknown, n)
+	}, node)
+	if got != node {
+		t.Errorf("got %#v, want the node back", got)
+	}
+	if len(unknown) != 1 || unknown[0] != node {
+		t.Errorf("got unknown nodes %v, want just the futureNode", unknown)
+	}
+}
+
+// The substitution comment comes out the same however the map is
+// iterated.
+func TestSubstitutionCommentOrder(t *testing.T) {
+	lookup := map[string]string{"V": "bool", "U": "string", "T": "int"}
+	want := substitutionComment("generic", lookup)
+	for i := 0; i < 50; i++ {
+		if got := substitutionComment("generic", lookup); got != want {
+			t.Fatalf("got:\n%s\nthen:\n%s", want, got)
+		}
+	}
+	if !strings.Contains(want, "T = int\n//\tgeneric.U = string\n//\tgeneric.V = bool\n") {
+		t.Errorf("not in T, U, V order:\n%s", want)
+	}
+}
+
+// CheckArgs reports the problems ParseArgs stops at along with those
+// in the types themselves, in order.
+func TestCheckArgs(t *testing.T) {
+	errs := CheckArgs([]string{"W=int", "T=bool", "T=string", "U=map[int", "a", "b", "c", "d"})
+	want := []string{
+		"W=int: generic.W is not a generic type",
+		"T=string: generic.T given more than once",
+		"d: too many replacement types",
+		"generic.U: ",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got errors %v, want %d", errs, len(want))
+	}
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("error %d: got %q, want one starting with %q", i, err, want[i])
+		}
+	}
+
+	if errs := CheckArgs([]string{"int", "U=string"}); errs != nil {
+		t.Errorf("got errors %v for good arguments", errs)
+	}
+}
+
+func TestTypeOverrides(t *testing.T) {
+	files := map[string]string{
+		"list.go":          "type List generic.T",
+		"list_keyed.go":    "type Keyed generic.T",
+		"special_keyed.go": "type Special generic.T\n\ntype Value generic.U",
+	}
+	for name, decls := range files {
+		files[name] = "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\n" + decls + "\n"
+	}
+	names := writeFiles(t, files)
+
+	o := Options{TypeOverrides: []TypeOverride{
+		{Pattern: "*_keyed.go", Types: map[string]string{"T": "string"}},
+		{Pattern: "special_*.go", Types: map[string]string{"T": "bool", "U": "float64"}},
+	}}
+	converted, err := o.GeneratePackage(names, map[string]string{"T": "int", "U": "byte"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"list.go":          "type List int",
+		"list_keyed.go":    "type Keyed string",
+		"special_keyed.go": "type Special bool\n\ntype Value float64",
+	}
+	for _, file := range converted {
+		name := filepath.Base(file.Name)
+		if got := string(file.Source); got != "package p\n\n"+want[name]+"\n" {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want[name])
+		}
+	}
+}
+
+func TestGenerateArgs(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "pair.go")
+	src := "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype Pair struct {\n\tKey   generic.T\n\tValue generic.U\n}\n"
+	if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := Generate(name, map[string]string{"T": "int", "U": "string"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"int", "string"},
+		{"T=int", "U=string"},
+		{"int", "U=string"},
+		{"U=string", "int"},
+	} {
+		got, err := GenerateArgs(name, args...)
+		if err != nil {
+			t.Errorf("GenerateArgs(%q): %s", args, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("GenerateArgs(%q) =\n%s\nwant:\n%s", args, got, want)
+		}
+	}
+
+	if _, err := GenerateArgs(name, "int", "T=string", "T=bool"); err == nil {
+		t.Error("GenerateArgs with T given twice succeeded")
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Comments and blank lines around the generic types of
+// testdata/comments.go, including an embedded one, stay where they are.
+func TestGolden(t *testing.T) {
+	got, err := Generate(filepath.Join("testdata", "comments.go"), map[string]string{"T": "string", "U": "bytes.Buffer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, got)
+
+	golden := filepath.Join("testdata", "comments.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	lookup := map[string]string{"T": "int", "U": "*big.Int"}
+	tests := map[string]string{
+		"store_T_.go":     "store_int.go",
+		"store_T_test.go": "store_int_test.go",
+		"map_T_U_.go":     "map_int_bigint.go",
+		"store_V_.go":     "store_V_.go",
+		"x_.go":           "x_.go",
+		"x_T_y_.go":       "x_int_y_.go",
+	}
+	for name, want := range tests {
+		if got := FileName(name, lookup); got != want {
+			t.Errorf("FileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Split drops the imports a file doesn't use, but only when it knows
+// the name they're used by.
+func TestSplitImports(t *testing.T) {
+	src := `package p
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+	"example.com/x/v2"
+)
+
+type A struct{}
+
+func (A) S() string { return fmt.Sprint(rand.N(3), x.Y) }
+
+type B struct{}
+
+func (B) S() string { return strings.ToUpper(yaml.Z) }
+`
+	files, err := Split("p.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"a.go": {"fmt", "math/rand/v2", "example.com/x/v2"},
+		"b.go": {"strings", "gopkg.in/yaml.v3", "example.com/x/v2"},
+		"p.go": {"example.com/x/v2"},
+	}
+	for name, paths := range want {
+		f, err := parser.ParseFile(token.NewFileSet(), name, files[name], parser.ImportsOnly)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		got := importPaths(f)
+		if len(got) != len(paths) {
+			t.Errorf("%s: got imports %v, want %v", name, got, paths)
+		}
+		for _, path := range paths {
+			if !got[path] {
+				t.Errorf("%s: got imports %v, want %v", name, got, paths)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package genlib
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// header splits src into the comments preceding the package clause
+// and everything from the package clause on.
+func header(src []byte) (head, rest []byte, err error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.PackageClauseOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the package clause's doc comment stays attached to it
+	start := f.Package
+	if f.Doc != nil {
+		start = f.Doc.Pos()
+	}
+
+	off := fset.Position(start).Offset
+	return src[:off], src[off:], nil
+}
+
+// AddBuildTags guards src with a build constraint requiring all of
+// tags, as Options.OutputBuildTags does for generated files.  A tag
+// may be negated with a leading "!".
+func AddBuildTags(src []byte, tags ...string) ([]byte, error) {
+	src, err := addBuildTags(src, tags)
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(src)
+}
+
+// addBuildTags prepends a //go:build line requiring all of tags.
+// Existing constraints in the header are folded into the new
+// expression and removed, legacy // +build lines included.
+func addBuildTags(src []byte, tags []string) ([]byte, error) {
+	expr, err := constraint.Parse("//go:build " + strings.Join(tags, " && "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid build tags %q: %s", tags, err)
+	}
+
+	head, rest, err := header(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept bytes.Buffer
+	for _, line := range strings.SplitAfter(string(head), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case constraint.IsGoBuild(trimmed):
+			x, err := constraint.Parse(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			expr = &constraint.AndExpr{X: x, Y: expr}
+		case constraint.IsPlusBuild(trimmed):
+			// regenerated from expr below
+		default:
+			kept.WriteString(line)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "//go:build %s\n\n", expr)
+	buf.Write(kept.Bytes())
+	buf.Write(rest)
+	return buf.Bytes(), nil
+}
+
+// AddLicense prepends license to src, as Options.LicenseHeader does
+// for generated files.
+func AddLicense(src []byte, license string) ([]byte, error) {
+	return format.Source(addLicense(src, license))
+}
+
+// addLicense prepends license to src as a comment block followed by a
+// blank line, so it's never mistaken for the package doc comment.
+func addLicense(src []byte, license string) []byte {
+	license = strings.TrimSpace(license)
+
+	var buf bytes.Buffer
+	if strings.HasPrefix(license, "//") || strings.HasPrefix(license, "/*") {
+		buf.WriteString(license)
+		buf.WriteString("\n")
+	} else {
+		for _, line := range strings.Split(license, "\n") {
+			if line = strings.TrimRight(line, " \t"); line == "" {
+				buf.WriteString("//\n")
+			} else {
+				buf.WriteString("// " + line + "\n")
+			}
+		}
+	}
+
+	buf.WriteString("\n")
+	buf.Write(src)
+	return buf.Bytes()
+}
+
+// substitutionComment lists the replacement for each generic type,
+// named as in the generic package pkg, in the order T, U, V whatever
+// order lookup gives them in, as an indented block, which formatting
+// leaves alone:
+//
+//	// Generated with these substitutions:
+//	//
+//	//	generic.T = int
+//	//	generic.U = string
+func substitutionComment(pkg string, lookup map[string]string) string {
+	var buf bytes.Buffer
+	buf.WriteString("// Generated with these substitutions:\n//\n")
+	for _, alias := range sortedKeys(lookup) {
+		fmt.Fprintf(&buf, "//\t%s.%s = %s\n", pkg, alias, lookup[alias])
+	}
+	return buf.String()
+}
@@ -0,0 +1,218 @@
+package genlib
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Import tells Generate which package a name used in replacement
+// types refers to, for packages goimports can't find on its own or
+// whose name clashes with one already used in the template.
+type Import struct {
+	Name  string // package name used in replacement types, e.g. "foo"
+	Path  string // import path, e.g. "example.com/x/foo"
+	Alias string // local name to import it as instead, if set
+}
+
+// qualifyTypes rewrites the package names in the replacement types in
+// lookup to the aliases given in imports, and returns the imports the
+// types use.
+func qualifyTypes(lookup map[string]string, imports []Import) (map[string]string, []Import, error) {
+	if len(imports) == 0 {
+		return lookup, nil, nil
+	}
+
+	byName := make(map[string]Import, len(imports))
+	for _, imp := range imports {
+		byName[imp.Name] = imp
+	}
+
+	used := make(map[string]Import)
+	qualified := make(map[string]string, len(lookup))
+	for alias, name := range lookup {
+		expr, err := parseType(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ast.Inspect(expr, func(node ast.Node) bool {
+			se, ok := node.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if x, ok := se.X.(*ast.Ident); ok {
+				if imp, ok := byName[x.Name]; ok {
+					used[imp.Name] = imp
+					if imp.Alias != "" {
+						x.Name = imp.Alias
+					}
+				}
+			}
+			return false
+		})
+
+		var buf bytes.Buffer
+		if err := format.Node(&buf, token.NewFileSet(), expr); err != nil {
+			return nil, nil, err
+		}
+		qualified[alias] = buf.String()
+	}
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var imps []Import
+	for _, name := range names {
+		imps = append(imps, used[name])
+	}
+	return qualified, imps, nil
+}
+
+// stdImports returns the standard library packages that the types in
+// lookup, where applied, qualify names with and that f doesn't already
+// import a package of the same name for, as in "time" for time.Time.
+// Packages named in imports are left to qualifyTypes, and names that
+// aren't unique in the standard library, like rand, to goimports.
+func stdImports(f *ast.File, lookup map[string]string, applied map[string]bool, imports []Import) []string {
+	bound := make(map[string]bool)
+	for _, imp := range imports {
+		bound[imp.Name] = true
+	}
+	for _, imp := range f.Imports {
+		name, _ := importName(imp)
+		bound[name] = true
+	}
+
+	paths := make(map[string]bool)
+	for alias, typ := range lookup {
+		if !applied[alias] {
+			continue
+		}
+		expr, err := parseType(typ)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(expr, func(node ast.Node) bool {
+			se, ok := node.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if x, ok := se.X.(*ast.Ident); ok && !bound[x.Name] {
+				if path := stdPackages()[x.Name]; path != "" {
+					paths[path] = true
+				}
+			}
+			return false
+		})
+	}
+
+	var list []string
+	for path := range paths {
+		list = append(list, path)
+	}
+	sort.Strings(list)
+	return list
+}
+
+var (
+	stdOnce  sync.Once
+	stdPkgs  map[string]string
+	stdNames map[string]string
+)
+
+// stdPackages returns the import paths of the standard library's
+// packages keyed by name, for the names only one of them has.
+func stdPackages() map[string]string {
+	stdOnce.Do(loadStd)
+	return stdPkgs
+}
+
+// loadStd reads the package clauses of the standard library in
+// GOROOT, as the go/build package finds it, for stdPackages and
+// importName.  Both are empty if it can't be read.
+func loadStd() {
+	stdPkgs = make(map[string]string)
+	stdNames = make(map[string]string)
+	if build.Default.GOROOT == "" {
+		return
+	}
+	root := filepath.Join(build.Default.GOROOT, "src")
+
+	count := make(map[string]int)
+	filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case "testdata", "vendor", "internal":
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." {
+			return nil
+		}
+		path := filepath.ToSlash(rel)
+		if path == "cmd" {
+			return filepath.SkipDir
+		}
+
+		pkg, err := build.Default.ImportDir(dir, 0)
+		if err != nil || pkg.Name == "main" {
+			return nil
+		}
+		count[pkg.Name]++
+		stdPkgs[pkg.Name] = path
+		stdNames[path] = pkg.Name
+		return nil
+	})
+	for name, n := range count {
+		if n > 1 {
+			delete(stdPkgs, name)
+		}
+	}
+}
+
+// importName returns the name imp binds in its file and whether it is
+// known: an explicit name, or the name of the standard library package
+// imported.  Otherwise it guesses from the path, skipping a major
+// version suffix as in "example.com/x/v2", and the guess can be wrong.
+func importName(imp *ast.ImportSpec) (string, bool) {
+	if imp.Name != nil {
+		return imp.Name.Name, true
+	}
+	path, err := strconv.Unquote(imp.Path.Value)
+	if err != nil {
+		return "", false
+	}
+	stdOnce.Do(loadStd)
+	if name, ok := stdNames[path]; ok {
+		return name, true
+	}
+
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	return name, false
+}
+
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(elem[1:])
+	return err == nil
+}
@@ -0,0 +1,43 @@
+package genlib
+
+import (
+	"bytes"
+	"go/format"
+	"strings"
+	"text/template"
+)
+
+// initData is what an EmitInit template is executed with.
+type initData struct {
+	Package string // name of the generated package
+	T, U, V string // replacement types, empty if not given
+}
+
+// addInit executes the template body and appends the result to the
+// formatted source src as an init function.
+func addInit(src []byte, pkg, body string, lookup map[string]string) ([]byte, error) {
+	tmpl, err := template.New("init").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	data := initData{
+		Package: pkg,
+		T:       lookup["T"],
+		U:       lookup["U"],
+		V:       lookup["V"],
+	}
+
+	var buf bytes.Buffer
+	buf.Write(src)
+	buf.WriteString("\nfunc init() {\n")
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(buf.String(), "\n") {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,59 @@
+package genlib
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// declLines returns the line each top-level declaration of f, other
+// than imports, starts on.  Doc comments are not counted, since
+// DocTypes may make them longer.
+func declLines(fset *token.FileSet, f *ast.File) []int {
+	var lines []int
+	for _, decl := range declsWithoutImports(f) {
+		lines = append(lines, fset.Position(decl.Pos()).Line)
+	}
+	return lines
+}
+
+// addLineDirectives puts a "//line name:N" directive right before
+// each top-level declaration of src other than imports, after its doc
+// comment, so that compiler errors and stack traces refer to the
+// template's lines.  The
+// declarations are matched in order with the template's, which lines
+// gives; any added after them, like a String method, are left alone.
+// Line breaks within a declaration are kept by the conversion, so one
+// directive each is enough.
+func addLineDirectives(src []byte, name string, lines []int) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	decls := declsWithoutImports(f)
+	if len(decls) > len(lines) {
+		decls = decls[:len(lines)]
+	}
+
+	// insert from the end so earlier offsets stay valid
+	for i := len(decls) - 1; i >= 0; i-- {
+		offset := fset.Position(decls[i].Pos()).Offset
+		directive := fmt.Sprintf("//line %s:%d\n", name, lines[i])
+		src = append(src[:offset], append([]byte(directive), src[offset:]...)...)
+	}
+	return src, nil
+}
+
+func declsWithoutImports(f *ast.File) []ast.Decl {
+	var decls []ast.Decl
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
@@ -0,0 +1,70 @@
+package genlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"go/version"
+	"io/ioutil"
+	"runtime"
+	"strings"
+)
+
+// parseFile parses the named file, adding a hint to syntax errors in
+// files whose build constraint asks for a newer Go than gengen was
+// built with.
+func parseFile(fset *token.FileSet, filename string) (*ast.File, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(fset, filename, src)
+}
+
+// parseSource is like parseFile for source that's already been read.
+func parseSource(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		if hint := versionHint(src); hint != "" {
+			return nil, fmt.Errorf("%s\n%s", err, hint)
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// versionHint suggests rebuilding gengen when src states, in a
+// //go:build constraint, that it needs a newer Go than gengen was
+// built with, since that's likely why it doesn't parse.
+func versionHint(src []byte) string {
+	if v := fileGoVersion(src); v != "" && version.IsValid(runtime.Version()) && version.Compare(v, runtime.Version()) > 0 {
+		return fmt.Sprintf("the file requires %s, newer than the %s gengen was built with; try rebuilding gengen with a newer Go", v, runtime.Version())
+	}
+	return ""
+}
+
+// fileGoVersion returns the minimum Go version required by src's
+// //go:build constraint, if any.
+func fileGoVersion(src []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if !constraint.IsGoBuild(line) {
+			continue
+		}
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			return ""
+		}
+		return constraint.GoVersion(expr)
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package genlib
+
+import (
+	"go/ast"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholder matches the {{T}} form generic types are written in
+// inside string literals and struct tags, where a plain T or generic.T
+// could be ordinary text.
+var placeholder = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+// fillPlaceholders replaces the placeholders in the string literal lit
+// with their replacements from lookup, keeping its quoting style where
+// it can.  Placeholders for types without a replacement are left as
+// they are.  It returns the generic types it replaced, once for each
+// placeholder.
+func fillPlaceholders(lit *ast.BasicLit, lookup map[string]string) []string {
+	if !strings.Contains(lit.Value, "{{") {
+		return nil
+	}
+
+	var used []string
+	fill := func(quote func(string) string) func(string) string {
+		return func(m string) string {
+			alias := m[2 : len(m)-2]
+			typ, ok := lookup[alias]
+			if !ok {
+				return m
+			}
+			used = append(used, alias)
+			return quote(typ)
+		}
+	}
+
+	// a raw string can't hold a backquote, so if a replacement has
+	// one the literal has to become an interpreted string
+	if lit.Value[0] == '`' {
+		value := placeholder.ReplaceAllStringFunc(lit.Value[1:len(lit.Value)-1], fill(func(typ string) string { return typ }))
+		if !strings.Contains(value, "`") {
+			lit.Value = "`" + value + "`"
+			return used
+		}
+		lit.Value = strconv.Quote(value)
+		return used
+	}
+
+	// the rest of an interpreted string is kept as written, escapes
+	// and all, and only the replacements are escaped
+	lit.Value = placeholder.ReplaceAllStringFunc(lit.Value, fill(func(typ string) string {
+		q := strconv.Quote(typ)
+		return q[1 : len(q)-1]
+	}))
+	return used
+}
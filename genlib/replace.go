@@ -7,38 +7,40 @@
 
 package genlib
 
-import (
-	"fmt"
-	"go/ast"
-)
+import "go/ast"
 
 type ReplaceFunc func(ast.Node) ast.Node
 
-func replaceIdentList(r ReplaceFunc, list []*ast.Ident) {
+// UnknownFunc is called with each node replace doesn't know how to
+// walk, such as one added to go/ast since this was written.  The node
+// and its children are left as they are.
+type UnknownFunc func(ast.Node)
+
+func replaceIdentList(r ReplaceFunc, u UnknownFunc, list []*ast.Ident) {
 	for i, x := range list {
-		list[i] = replace(r, x).(*ast.Ident)
+		list[i] = replace(r, u, x).(*ast.Ident)
 	}
 }
 
-func replaceExprList(r ReplaceFunc, list []ast.Expr) {
+func replaceExprList(r ReplaceFunc, u UnknownFunc, list []ast.Expr) {
 	for i, x := range list {
-		list[i] = replace(r, x).(ast.Expr)
+		list[i] = replace(r, u, x).(ast.Expr)
 	}
 }
 
-func replaceStmtList(r ReplaceFunc, list []ast.Stmt) {
+func replaceStmtList(r ReplaceFunc, u UnknownFunc, list []ast.Stmt) {
 	for i, x := range list {
-		list[i] = replace(r, x).(ast.Stmt)
+		list[i] = replace(r, u, x).(ast.Stmt)
 	}
 }
 
-func replaceDeclList(r ReplaceFunc, list []ast.Decl) {
+func replaceDeclList(r ReplaceFunc, u UnknownFunc, list []ast.Decl) {
 	for i, x := range list {
-		list[i] = replace(r, x).(ast.Decl)
+		list[i] = replace(r, u, x).(ast.Decl)
 	}
 }
 
-func replace(r ReplaceFunc, node ast.Node) ast.Node {
+func replace(r ReplaceFunc, u UnknownFunc, node ast.Node) ast.Node {
 	node = r(node)
 
 	if node == nil {
@@ -55,29 +57,29 @@ func replace(r ReplaceFunc, node ast.Node) ast.Node {
 
 	case *ast.CommentGroup:
 		for i, c := range n.List {
-			n.List[i] = replace(r, c).(*ast.Comment)
+			n.List[i] = replace(r, u, c).(*ast.Comment)
 		}
 
 	case *ast.Field:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
-		replaceIdentList(r, n.Names)
+		replaceIdentList(r, u, n.Names)
 
-		n.Type = replace(r, n.Type).(ast.Expr)
+		n.Type = replace(r, u, n.Type).(ast.Expr)
 
 		if n.Tag != nil {
-			n.Tag = replace(r, n.Tag).(*ast.BasicLit)
+			n.Tag = replace(r, u, n.Tag).(*ast.BasicLit)
 		}
 
 		if n.Comment != nil {
-			n.Comment = replace(r, n.Comment).(*ast.CommentGroup)
+			n.Comment = replace(r, u, n.Comment).(*ast.CommentGroup)
 		}
 
 	case *ast.FieldList:
 		for i, f := range n.List {
-			n.List[i] = replace(r, f).(*ast.Field)
+			n.List[i] = replace(r, u, f).(*ast.Field)
 		}
 
 	// Expressions
@@ -86,253 +88,272 @@ func replace(r ReplaceFunc, node ast.Node) ast.Node {
 
 	case *ast.Ellipsis:
 		if n.Elt != nil {
-			n.Elt = replace(r, n.Elt).(ast.Expr)
+			n.Elt = replace(r, u, n.Elt).(ast.Expr)
 		}
 
 	case *ast.FuncLit:
-		n.Type = replace(r, n.Type).(*ast.FuncType)
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.Type = replace(r, u, n.Type).(*ast.FuncType)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 	case *ast.CompositeLit:
 		if n.Type != nil {
-			n.Type = replace(r, n.Type).(ast.Expr)
+			n.Type = replace(r, u, n.Type).(ast.Expr)
 		}
 
-		replaceExprList(r, n.Elts)
+		replaceExprList(r, u, n.Elts)
 
 	case *ast.ParenExpr:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 	case *ast.SelectorExpr:
-		n.X = replace(r, n.X).(ast.Expr)
-		n.Sel = replace(r, n.Sel).(*ast.Ident)
+		n.X = replace(r, u, n.X).(ast.Expr)
+		n.Sel = replace(r, u, n.Sel).(*ast.Ident)
 
 	case *ast.IndexExpr:
-		n.X = replace(r, n.X).(ast.Expr)
-		n.Index = replace(r, n.Index).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
+		n.Index = replace(r, u, n.Index).(ast.Expr)
+
+	case *ast.IndexListExpr:
+		n.X = replace(r, u, n.X).(ast.Expr)
+		replaceExprList(r, u, n.Indices)
 
 	case *ast.SliceExpr:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 		if n.Low != nil {
-			n.Low = replace(r, n.Low).(ast.Expr)
+			n.Low = replace(r, u, n.Low).(ast.Expr)
 		}
 
 		if n.High != nil {
-			n.High = replace(r, n.High).(ast.Expr)
+			n.High = replace(r, u, n.High).(ast.Expr)
+		}
+
+		if n.Max != nil {
+			n.Max = replace(r, u, n.Max).(ast.Expr)
 		}
 
 	case *ast.TypeAssertExpr:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 		if n.Type != nil {
-			n.Type = replace(r, n.Type).(ast.Expr)
+			n.Type = replace(r, u, n.Type).(ast.Expr)
 		}
 
 	case *ast.CallExpr:
-		n.Fun = replace(r, n.Fun).(ast.Expr)
-		replaceExprList(r, n.Args)
+		n.Fun = replace(r, u, n.Fun).(ast.Expr)
+		replaceExprList(r, u, n.Args)
 
 	case *ast.StarExpr:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 	case *ast.UnaryExpr:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 	case *ast.BinaryExpr:
-		n.X = replace(r, n.X).(ast.Expr)
-		n.Y = replace(r, n.Y).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
+		n.Y = replace(r, u, n.Y).(ast.Expr)
 
 	case *ast.KeyValueExpr:
-		n.Key = replace(r, n.Key).(ast.Expr)
-		n.Value = replace(r, n.Value).(ast.Expr)
+		n.Key = replace(r, u, n.Key).(ast.Expr)
+		n.Value = replace(r, u, n.Value).(ast.Expr)
 
 	// Types
 	case *ast.ArrayType:
 		if n.Len != nil {
-			n.Len = replace(r, n.Len).(ast.Expr)
+			n.Len = replace(r, u, n.Len).(ast.Expr)
 		}
 
-		n.Elt = replace(r, n.Elt).(ast.Expr)
+		n.Elt = replace(r, u, n.Elt).(ast.Expr)
 
 	case *ast.StructType:
-		n.Fields = replace(r, n.Fields).(*ast.FieldList)
+		n.Fields = replace(r, u, n.Fields).(*ast.FieldList)
 
 	case *ast.FuncType:
-		n.Params = replace(r, n.Params).(*ast.FieldList)
+		if n.TypeParams != nil {
+			n.TypeParams = replace(r, u, n.TypeParams).(*ast.FieldList)
+		}
+
+		n.Params = replace(r, u, n.Params).(*ast.FieldList)
 
 		if n.Results != nil {
-			n.Results = replace(r, n.Results).(*ast.FieldList)
+			n.Results = replace(r, u, n.Results).(*ast.FieldList)
 		}
 
 	case *ast.InterfaceType:
-		n.Methods = replace(r, n.Methods).(*ast.FieldList)
+		n.Methods = replace(r, u, n.Methods).(*ast.FieldList)
 
 	case *ast.MapType:
-		n.Key = replace(r, n.Key).(ast.Expr)
-		n.Value = replace(r, n.Value).(ast.Expr)
+		n.Key = replace(r, u, n.Key).(ast.Expr)
+		n.Value = replace(r, u, n.Value).(ast.Expr)
 
 	case *ast.ChanType:
-		n.Value = replace(r, n.Value).(ast.Expr)
+		n.Value = replace(r, u, n.Value).(ast.Expr)
 
 	// Statements
 	case *ast.BadStmt:
 		// nothing to do
 
 	case *ast.DeclStmt:
-		n.Decl = replace(r, n.Decl).(ast.Decl)
+		n.Decl = replace(r, u, n.Decl).(ast.Decl)
 
 	case *ast.EmptyStmt:
 		// nothing to do
 
 	case *ast.LabeledStmt:
-		n.Label = replace(r, n.Label).(*ast.Ident)
-		n.Stmt = replace(r, n.Stmt).(ast.Stmt)
+		n.Label = replace(r, u, n.Label).(*ast.Ident)
+		n.Stmt = replace(r, u, n.Stmt).(ast.Stmt)
 
 	case *ast.ExprStmt:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 	case *ast.SendStmt:
-		n.Chan = replace(r, n.Chan).(ast.Expr)
-		n.Value = replace(r, n.Value).(ast.Expr)
+		n.Chan = replace(r, u, n.Chan).(ast.Expr)
+		n.Value = replace(r, u, n.Value).(ast.Expr)
 
 	case *ast.IncDecStmt:
-		n.X = replace(r, n.X).(ast.Expr)
+		n.X = replace(r, u, n.X).(ast.Expr)
 
 	case *ast.AssignStmt:
-		replaceExprList(r, n.Lhs)
-		replaceExprList(r, n.Rhs)
+		replaceExprList(r, u, n.Lhs)
+		replaceExprList(r, u, n.Rhs)
 
 	case *ast.GoStmt:
-		n.Call = replace(r, n.Call).(*ast.CallExpr)
+		n.Call = replace(r, u, n.Call).(*ast.CallExpr)
 
 	case *ast.DeferStmt:
-		n.Call = replace(r, n.Call).(*ast.CallExpr)
+		n.Call = replace(r, u, n.Call).(*ast.CallExpr)
 
 	case *ast.ReturnStmt:
-		replaceExprList(r, n.Results)
+		replaceExprList(r, u, n.Results)
 
 	case *ast.BranchStmt:
 		if n.Label != nil {
-			n.Label = replace(r, n.Label).(*ast.Ident)
+			n.Label = replace(r, u, n.Label).(*ast.Ident)
 		}
 
 	case *ast.BlockStmt:
-		replaceStmtList(r, n.List)
+		replaceStmtList(r, u, n.List)
 
 	case *ast.IfStmt:
 		if n.Init != nil {
-			n.Init = replace(r, n.Init).(ast.Stmt)
+			n.Init = replace(r, u, n.Init).(ast.Stmt)
 		}
 
-		n.Cond = replace(r, n.Cond).(ast.Expr)
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.Cond = replace(r, u, n.Cond).(ast.Expr)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 		if n.Else != nil {
-			n.Else = replace(r, n.Else).(ast.Stmt)
+			n.Else = replace(r, u, n.Else).(ast.Stmt)
 		}
 
 	case *ast.CaseClause:
-		replaceExprList(r, n.List)
-		replaceStmtList(r, n.Body)
+		replaceExprList(r, u, n.List)
+		replaceStmtList(r, u, n.Body)
 
 	case *ast.SwitchStmt:
 		if n.Init != nil {
-			n.Init = replace(r, n.Init).(ast.Stmt)
+			n.Init = replace(r, u, n.Init).(ast.Stmt)
 		}
 
 		if n.Tag != nil {
-			n.Tag = replace(r, n.Tag).(ast.Expr)
+			n.Tag = replace(r, u, n.Tag).(ast.Expr)
 		}
 
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 	case *ast.TypeSwitchStmt:
 		if n.Init != nil {
-			n.Init = replace(r, n.Init).(ast.Stmt)
+			n.Init = replace(r, u, n.Init).(ast.Stmt)
 		}
 
-		n.Assign = replace(r, n.Assign).(ast.Stmt)
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.Assign = replace(r, u, n.Assign).(ast.Stmt)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 	case *ast.CommClause:
 		if n.Comm != nil {
-			n.Comm = replace(r, n.Comm).(ast.Stmt)
+			n.Comm = replace(r, u, n.Comm).(ast.Stmt)
 		}
 
-		replaceStmtList(r, n.Body)
+		replaceStmtList(r, u, n.Body)
 
 	case *ast.SelectStmt:
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 	case *ast.ForStmt:
 		if n.Init != nil {
-			n.Init = replace(r, n.Init).(ast.Stmt)
+			n.Init = replace(r, u, n.Init).(ast.Stmt)
 		}
 
 		if n.Cond != nil {
-			n.Cond = replace(r, n.Cond).(ast.Expr)
+			n.Cond = replace(r, u, n.Cond).(ast.Expr)
 		}
 
 		if n.Post != nil {
-			n.Post = replace(r, n.Post).(ast.Stmt)
+			n.Post = replace(r, u, n.Post).(ast.Stmt)
 		}
 
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 	case *ast.RangeStmt:
-		n.Key = replace(r, n.Key).(ast.Expr)
+		if n.Key != nil {
+			n.Key = replace(r, u, n.Key).(ast.Expr)
+		}
 
 		if n.Value != nil {
-			n.Value = replace(r, n.Value).(ast.Expr)
+			n.Value = replace(r, u, n.Value).(ast.Expr)
 		}
 
-		n.X = replace(r, n.X).(ast.Expr)
-		n.Body = replace(r, n.Body).(*ast.BlockStmt)
+		n.X = replace(r, u, n.X).(ast.Expr)
+		n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 
 	// Declarations
 	case *ast.ImportSpec:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
 		if n.Name != nil {
-			n.Name = replace(r, n.Name).(*ast.Ident)
+			n.Name = replace(r, u, n.Name).(*ast.Ident)
 		}
 
-		n.Path = replace(r, n.Path).(*ast.BasicLit)
+		n.Path = replace(r, u, n.Path).(*ast.BasicLit)
 
 		if n.Comment != nil {
-			n.Comment = replace(r, n.Comment).(*ast.CommentGroup)
+			n.Comment = replace(r, u, n.Comment).(*ast.CommentGroup)
 		}
 
 	case *ast.ValueSpec:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
-		replaceIdentList(r, n.Names)
+		replaceIdentList(r, u, n.Names)
 
 		if n.Type != nil {
-			n.Type = replace(r, n.Type).(ast.Expr)
+			n.Type = replace(r, u, n.Type).(ast.Expr)
 		}
 
-		replaceExprList(r, n.Values)
+		replaceExprList(r, u, n.Values)
 
 		if n.Comment != nil {
-			n.Comment = replace(r, n.Comment).(*ast.CommentGroup)
+			n.Comment = replace(r, u, n.Comment).(*ast.CommentGroup)
 		}
 
 	case *ast.TypeSpec:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
-		n.Name = replace(r, n.Name).(*ast.Ident)
-		n.Type = replace(r, n.Type).(ast.Expr)
+		n.Name = replace(r, u, n.Name).(*ast.Ident)
+
+		if n.TypeParams != nil {
+			n.TypeParams = replace(r, u, n.TypeParams).(*ast.FieldList)
+		}
+
+		n.Type = replace(r, u, n.Type).(ast.Expr)
 
 		if n.Comment != nil {
-			n.Comment = replace(r, n.Comment).(*ast.CommentGroup)
+			n.Comment = replace(r, u, n.Comment).(*ast.CommentGroup)
 		}
 
 	case *ast.BadDecl:
@@ -340,41 +361,41 @@ func replace(r ReplaceFunc, node ast.Node) ast.Node {
 
 	case *ast.GenDecl:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
 		for i, s := range n.Specs {
-			n.Specs[i] = replace(r, s).(ast.Spec)
+			n.Specs[i] = replace(r, u, s).(ast.Spec)
 		}
 
 	case *ast.FuncDecl:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
 		if n.Recv != nil {
-			n.Recv = replace(r, n.Recv).(*ast.FieldList)
+			n.Recv = replace(r, u, n.Recv).(*ast.FieldList)
 		}
 
-		n.Name = replace(r, n.Name).(*ast.Ident)
-		n.Type = replace(r, n.Type).(*ast.FuncType)
+		n.Name = replace(r, u, n.Name).(*ast.Ident)
+		n.Type = replace(r, u, n.Type).(*ast.FuncType)
 
 		if n.Body != nil {
-			n.Body = replace(r, n.Body).(*ast.BlockStmt)
+			n.Body = replace(r, u, n.Body).(*ast.BlockStmt)
 		}
 
 	// Files and packages
 	case *ast.File:
 		if n.Doc != nil {
-			n.Doc = replace(r, n.Doc).(*ast.CommentGroup)
+			n.Doc = replace(r, u, n.Doc).(*ast.CommentGroup)
 		}
 
-		n.Name = replace(r, n.Name).(*ast.Ident)
+		n.Name = replace(r, u, n.Name).(*ast.Ident)
 
-		replaceDeclList(r, n.Decls)
+		replaceDeclList(r, u, n.Decls)
 
 		for i, g := range n.Comments {
-			n.Comments[i] = replace(r, g).(*ast.CommentGroup)
+			n.Comments[i] = replace(r, u, g).(*ast.CommentGroup)
 		}
 
 		// don't walk n.Comments - they have been
@@ -383,12 +404,13 @@ func replace(r ReplaceFunc, node ast.Node) ast.Node {
 
 	case *ast.Package:
 		for i, f := range n.Files {
-			n.Files[i] = replace(r, f).(*ast.File)
+			n.Files[i] = replace(r, u, f).(*ast.File)
 		}
 
 	default:
-		fmt.Printf("replace: unexpected node type %T", n)
-		panic("replace")
+		if u != nil {
+			u(n)
+		}
 	}
 
 	return node
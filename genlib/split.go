@@ -0,0 +1,242 @@
+package genlib
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Split partitions the formatted source of a generated file so that
+// each top-level type declared in it goes into a file of its own,
+// together with its methods.  Type files are named after the lowercased
+// type name ("Tree" becomes "tree.go", or "tree_test.go" when splitting
+// a test file).  Everything else, including grouped type declarations
+// and methods on types declared elsewhere, stays in filename.
+//
+// Each file keeps the build constraints and other comments preceding
+// the package clause, and only the imports it uses, as far as can be
+// told without loading them: an import of a package whose name isn't
+// known is kept in every file.  The result is keyed by base file name.
+func Split(filename string, src []byte) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	head, _, err := header(src)
+	if err != nil {
+		return nil, err
+	}
+
+	mainName := filepath.Base(filename)
+	tok := fset.File(f.Pos())
+
+	// lineEnd returns the offset just past the line containing p, so
+	// trailing comments stay with their declaration
+	lineEnd := func(p token.Pos) int {
+		off := tok.Offset(p)
+		if i := bytes.IndexByte(src[off:], '\n'); i >= 0 {
+			return off + i + 1
+		}
+		return len(src)
+	}
+
+	owners := make(map[string]string)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			name := spec.(*ast.TypeSpec).Name.Name
+			if out := splitFileName(mainName, name); out != mainName {
+				owners[name] = out
+			}
+		}
+	}
+
+	// the body starts after the package clause and any imports
+	var imports []byte
+	bodyStart := lineEnd(f.Name.End())
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		start := tok.Offset(gd.Pos())
+		if gd.Doc != nil {
+			start = tok.Offset(gd.Doc.Pos())
+		}
+		end := lineEnd(gd.End())
+		imports = append(imports, src[start:end]...)
+		imports = append(imports, '\n')
+		bodyStart = end
+	}
+
+	bodies := map[string]*bytes.Buffer{mainName: new(bytes.Buffer)}
+	body := func(name string) *bytes.Buffer {
+		if bodies[name] == nil {
+			bodies[name] = new(bytes.Buffer)
+		}
+		return bodies[name]
+	}
+
+	prev := bodyStart
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if ok && gd.Tok == token.IMPORT {
+			continue
+		}
+
+		// comments between declarations go with the one following
+		end := lineEnd(decl.End())
+
+		if ok && gd.Tok == token.TYPE && gd.Lparen.IsValid() {
+			splitGroup(src, gd, tok, prev, lineEnd, owners, mainName, body)
+			prev = end
+			continue
+		}
+
+		owner := mainName
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			if d.Tok == token.TYPE {
+				if out, ok := owners[d.Specs[0].(*ast.TypeSpec).Name.Name]; ok {
+					owner = out
+				}
+			}
+		case *ast.FuncDecl:
+			if out, ok := owners[recvTypeName(d)]; ok {
+				owner = out
+			}
+		}
+
+		body(owner).Write(src[prev:end])
+		prev = end
+	}
+	bodies[mainName].Write(src[prev:])
+
+	out := make(map[string][]byte, len(bodies))
+	for name, body := range bodies {
+		var buf bytes.Buffer
+		if name == mainName {
+			buf.Write(src[:bodyStart])
+		} else {
+			buf.Write(head)
+			buf.WriteString("package " + f.Name.Name + "\n\n")
+			buf.Write(imports)
+		}
+		buf.Write(body.Bytes())
+
+		pruned, err := pruneImports(buf.Bytes(), name == mainName)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = pruned
+	}
+
+	return out, nil
+}
+
+// splitGroup distributes the specs of a grouped type declaration,
+// which spans from start to the end of its closing paren's line.
+// Specs moving to a file of their own become standalone declarations;
+// the rest stay grouped in the main file.
+func splitGroup(src []byte, gd *ast.GenDecl, tok *token.File, start int, lineEnd func(token.Pos) int, owners map[string]string, mainName string, body func(string) *bytes.Buffer) {
+	var kept bytes.Buffer
+	prev := lineEnd(gd.Lparen)
+	for _, spec := range gd.Specs {
+		ts := spec.(*ast.TypeSpec)
+		end := lineEnd(ts.End())
+		if out, ok := owners[ts.Name.Name]; ok {
+			// the doc comment precedes the new "type" keyword; the
+			// indentation is fixed up when the file is formatted
+			b := body(out)
+			b.WriteString("\n")
+			b.Write(src[prev:tok.Offset(ts.Pos())])
+			b.WriteString("type ")
+			b.Write(src[tok.Offset(ts.Pos()):end])
+		} else {
+			kept.Write(src[prev:end])
+		}
+		prev = end
+	}
+
+	if kept.Len() > 0 {
+		b := body(mainName)
+		b.Write(src[start:lineEnd(gd.Lparen)])
+		b.Write(kept.Bytes())
+		b.Write(src[prev:lineEnd(gd.End())])
+	}
+}
+
+func splitFileName(mainName, typeName string) string {
+	name := strings.ToLower(typeName)
+	if strings.HasSuffix(mainName, "_test.go") {
+		return name + "_test.go"
+	}
+	return name + ".go"
+}
+
+// pruneImports removes the imports src doesn't use.  Blank and dot
+// imports can't be checked, so they are kept only if keepUnchecked is
+// set.  Neither can imports whose name isn't known for sure, which
+// are always kept.
+func pruneImports(src []byte, keepUnchecked bool) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// deleting an import modifies f.Imports
+	imps := append([]*ast.ImportSpec(nil), f.Imports...)
+	for _, imp := range imps {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		if imp.Name != nil && (imp.Name.Name == "_" || imp.Name.Name == ".") {
+			if !keepUnchecked {
+				astutil.DeleteNamedImport(fset, f, imp.Name.Name, path)
+			}
+			continue
+		}
+		if name, known := importName(imp); known && !usesName(f, name) {
+			if imp.Name == nil {
+				name = ""
+			}
+			astutil.DeleteNamedImport(fset, f, name, path)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// usesName reports whether f refers to a package imported as name.
+func usesName(f *ast.File, name string) bool {
+	used := false
+	ast.Inspect(f, func(node ast.Node) bool {
+		se, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return !used
+		}
+		if x, ok := se.X.(*ast.Ident); ok && x.Name == name && x.Obj == nil {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
@@ -0,0 +1,156 @@
+package genlib
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// addStringer appends a String method for the named top-level type to
+// the formatted source src.  The method formats the value converted to
+// its underlying type, which keeps fmt from calling String recursively:
+//
+//	func (v Color) String() string {
+//		return fmt.Sprintf("Color(%v)", int(v))
+//	}
+func addStringer(src []byte, name string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := findTypeSpec(f, name)
+	if spec == nil {
+		return nil, fmt.Errorf("no top-level type %q for String method", name)
+	}
+	if spec.Assign.IsValid() {
+		return nil, fmt.Errorf("can't add a String method to alias %q", name)
+	}
+	if hasMethod(f, name, "String") {
+		return nil, fmt.Errorf("type %q already has a String method", name)
+	}
+
+	var typ bytes.Buffer
+	if err := format.Node(&typ, fset, spec.Type); err != nil {
+		return nil, err
+	}
+
+	// types like *int or func() int need parens to be converted to
+	conv := typ.String()
+	switch spec.Type.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+	default:
+		conv = "(" + conv + ")"
+	}
+
+	fmtName, imported := fmtImport(f)
+
+	var buf bytes.Buffer
+	buf.Write(src)
+	fmt.Fprintf(&buf, "\nfunc (v %s) String() string {\n", name)
+	fmt.Fprintf(&buf, "\treturn %s.Sprintf(%s, %s(v))\n}\n", fmtName, strconv.Quote(name+"(%v)"), conv)
+
+	// reparse so the new method has real positions and comments
+	// stay where they were
+	fset = token.NewFileSet()
+	f, err = parser.ParseFile(fset, "", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if !imported {
+		if fmtName == "fmt" {
+			astutil.AddImport(fset, f, "fmt")
+		} else {
+			astutil.AddNamedImport(fset, f, fmtName, "fmt")
+		}
+	}
+
+	buf.Reset()
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fmtImport returns the name f imports fmt under, if it does, and
+// whether it does.  Otherwise it returns a name to import it under
+// that nothing else at the top level of f uses, "fmt" if possible.
+func fmtImport(f *ast.File) (string, bool) {
+	used := make(map[string]bool)
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if path == "fmt" && name != "_" && name != "." {
+			return name, true
+		}
+		used[name] = true
+	}
+	for name := range f.Scope.Objects {
+		used[name] = true
+	}
+
+	name := "fmt"
+	for i := 2; used[name]; i++ {
+		name = "fmt" + strconv.Itoa(i)
+	}
+	return name, false
+}
+
+func findTypeSpec(f *ast.File, name string) *ast.TypeSpec {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			if ts := spec.(*ast.TypeSpec); ts.Name.Name == name {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+// hasMethod reports whether f declares method on typeName, with
+// either a value or pointer receiver.
+func hasMethod(f *ast.File, typeName, method string) bool {
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Recv == nil || fd.Name.Name != method {
+			continue
+		}
+		if recvTypeName(fd) == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// recvTypeName returns the name of the type a method is declared on.
+func recvTypeName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return ""
+	}
+	t := fd.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
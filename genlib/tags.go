@@ -0,0 +1,121 @@
+package genlib
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// typePlaceholder is replaced with a field's type in the values of the
+// struct tag keys named by Options.TypeTagKeys.
+const typePlaceholder = "__TYPE__"
+
+// fillTypeTags replaces typePlaceholder in the values of the given
+// keys of each struct field's tag with the field's (substituted) type,
+// so that
+//
+//	Value generic.T `schema:"type=__TYPE__"`
+//
+// becomes `schema:"type=int"` with T=int.
+func fillTypeTags(fset *token.FileSet, f *ast.File, keys []string) error {
+	var err error
+	ast.Inspect(f, func(node ast.Node) bool {
+		field, ok := node.(*ast.Field)
+		if !ok || field.Tag == nil || err != nil {
+			return err == nil
+		}
+		if !strings.Contains(field.Tag.Value, typePlaceholder) {
+			return true
+		}
+
+		var buf bytes.Buffer
+		if err = format.Node(&buf, fset, field.Type); err != nil {
+			return false
+		}
+
+		var tag string
+		if tag, err = strconv.Unquote(field.Tag.Value); err != nil {
+			err = fmt.Errorf("%s: bad struct tag %s", fset.Position(field.Tag.Pos()), field.Tag.Value)
+			return false
+		}
+		if tag, err = replaceTagValues(tag, keys, buf.String()); err != nil {
+			err = fmt.Errorf("%s: %s", fset.Position(field.Tag.Pos()), err)
+			return false
+		}
+
+		if strings.HasPrefix(field.Tag.Value, "`") && !strings.Contains(tag, "`") {
+			field.Tag.Value = "`" + tag + "`"
+		} else {
+			field.Tag.Value = strconv.Quote(tag)
+		}
+		return true
+	})
+	return err
+}
+
+// replaceTagValues replaces typePlaceholder with typ in the values of
+// keys in tag, which is in the conventional format reflect.StructTag
+// describes.  Everything else in the tag is left as it was.
+func replaceTagValues(tag string, keys []string, typ string) (string, error) {
+	var out strings.Builder
+	for tag != "" {
+		// skip leading space, as reflect.StructTag.Lookup does
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		out.WriteString(tag[:i])
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return "", fmt.Errorf("struct tag %q isn't in key:\"value\" form", tag)
+		}
+		key := tag[:i]
+		out.WriteString(tag[:i+1])
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return "", fmt.Errorf("struct tag value %s isn't terminated", tag)
+		}
+		quoted := tag[:i+1]
+		tag = tag[i+1:]
+
+		if !contains(keys, key) {
+			out.WriteString(quoted)
+			continue
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", fmt.Errorf("struct tag value %s: %s", quoted, err)
+		}
+		out.WriteString(strconv.Quote(strings.Replace(value, typePlaceholder, typ, -1)))
+	}
+	return out.String(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+// Package cache keeps values by key.
+package cache
+
+import (
+	"sync"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+// Entry is a cached value.
+type Entry struct {
+	// the value, embedded so its methods are the entry's
+
+	generic.U // trailing comment on the embedded field
+
+	/* when it expires */
+	expires int64
+
+	key generic.T // the key, for eviction
+}
+
+// Cache holds entries by key.
+type Cache struct {
+	mu sync.Mutex // guards entries
+
+	entries map[generic.T]*Entry // by key
+}
+
+// Get returns the entry for k.
+func (c *Cache) Get(k generic.T) (generic.U, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a miss returns the zero generic.U
+	var e *Entry
+	e, ok := c.entries[k]
+	if !ok {
+		var zero generic.U /* zero value */
+		return zero, false
+	}
+
+	return e.U, true // the embedded field
+}
+
+// Set stores v for k.
+func (c *Cache) Set(k generic.T, v generic.U) {
+	c.mu.Lock()
+	c.entries[k] = &Entry{
+		U: v, // embedded
+
+		key: k,
+	}
+	c.mu.Unlock()
+}
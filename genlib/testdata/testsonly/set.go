@@ -0,0 +1,6 @@
+package set
+
+// Set is a set of ints.
+type Set map[int]bool
+
+func (s Set) Add(v int) { s[v] = true }
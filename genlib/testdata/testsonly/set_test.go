@@ -0,0 +1,16 @@
+package set
+
+import (
+	"testing"
+
+	"github.com/joeshaw/gengen/generic"
+)
+
+func TestAdd(t *testing.T) {
+	s := make(Set)
+	var v generic.T
+	s.Add(v)
+	if !s[v] {
+		t.Errorf("%v not added", v)
+	}
+}
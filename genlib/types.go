@@ -0,0 +1,268 @@
+package genlib
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ParseArgs turns command line arguments into the replacement types
+// for Generate, keyed by generic type.  Positional arguments fill the
+// generic types in order, while an argument of the form "U=string"
+// names the generic type it replaces and overrides any positional
+// value for it:
+//
+//	int U=string   ->  T=int, U=string
+//	U=string T=int ->  T=int, U=string
+func ParseArgs(args []string) (map[string]string, error) {
+	lookup, errs := parseArgs(args)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return lookup, nil
+}
+
+// CheckArgs reports every problem with command line arguments for
+// ParseArgs, as well as those CheckTypes finds with the replacement
+// types they give.  It returns nil if all is well.
+func CheckArgs(args []string) []error {
+	lookup, errs := parseArgs(args)
+	return append(errs, CheckTypes(lookup)...)
+}
+
+// parseArgs does the work of ParseArgs, skipping the arguments it
+// can't use so it can report them all.
+func parseArgs(args []string) (map[string]string, []error) {
+	lookup := make(map[string]string)
+	named := make(map[string]bool)
+	var errs []error
+
+	pos := 0
+	for _, arg := range args {
+		if alias, value, ok := splitNamedArg(arg); ok {
+			if genericIndex(alias) < 0 {
+				errs = append(errs, fmt.Errorf("%s: %s.%s is not a generic type", arg, genericPkg, alias))
+				continue
+			}
+			if named[alias] {
+				errs = append(errs, fmt.Errorf("%s: %s.%s given more than once", arg, genericPkg, alias))
+				continue
+			}
+			named[alias] = true
+			lookup[alias] = value
+			continue
+		}
+
+		if pos >= len(genericTypes) {
+			errs = append(errs, fmt.Errorf("%s: too many replacement types, the generic package only defines %s",
+				arg, strings.Join(genericTypes, ", ")))
+			continue
+		}
+		if alias := genericTypes[pos]; !named[alias] {
+			lookup[alias] = arg
+		}
+		pos++
+	}
+
+	return lookup, errs
+}
+
+// splitNamedArg splits an "alias=type" argument.  The alias must be an
+// identifier, so "=" inside a type expression isn't mistaken for one.
+func splitNamedArg(arg string) (alias, value string, ok bool) {
+	i := strings.Index(arg, "=")
+	if i <= 0 || !token.IsIdentifier(arg[:i]) {
+		return "", "", false
+	}
+	return arg[:i], arg[i+1:], true
+}
+
+func genericIndex(alias string) int {
+	for i, t := range genericTypes {
+		if t == alias {
+			return i
+		}
+	}
+	return -1
+}
+
+// CheckTypes reports every problem with a set of replacement types:
+// keys that aren't generic types, or values that don't parse as Go
+// type expressions.  It returns nil if all is well.
+func CheckTypes(lookup map[string]string) []error {
+	var errs []error
+	for _, alias := range sortedKeys(lookup) {
+		if genericIndex(alias) < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s is not a generic type; the generic package only defines %s",
+				genericPkg, alias, strings.Join(genericTypes, ", ")))
+			continue
+		}
+		if _, err := parseType(lookup[alias]); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %s", genericPkg, alias, err))
+		}
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of lookup, generic types first in their
+// usual order.
+func sortedKeys(lookup map[string]string) []string {
+	keys := make([]string, 0, len(lookup))
+	for key := range lookup {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := genericIndex(keys[i]), genericIndex(keys[j])
+		if a < 0 {
+			a = len(genericTypes)
+		}
+		if b < 0 {
+			b = len(genericTypes)
+		}
+		if a != b {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// parseType parses s as a type expression.
+func parseType(s string) (ast.Expr, error) {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid type: %s", s, err)
+	}
+	if err := checkType(expr); err != nil {
+		return nil, fmt.Errorf("%q is not a valid type: %s", s, err)
+	}
+	return expr, nil
+}
+
+// typeExpr returns the type expression typ with every position set to
+// pos, where it replaces a generic type.  If typ doesn't parse, it's
+// returned as an identifier holding the text.
+func typeExpr(typ string, pos token.Pos) ast.Expr {
+	expr, err := parseType(typ)
+	if err != nil {
+		return &ast.Ident{NamePos: pos, Name: typ}
+	}
+
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if node == nil {
+			return false
+		}
+		v := reflect.ValueOf(node).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType && f.CanSet() {
+				f.Set(reflect.ValueOf(pos))
+			}
+		}
+		return true
+	})
+	return expr
+}
+
+// checkType returns an error if expr can't be a type.
+func checkType(expr ast.Expr) error {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return nil
+	case *ast.SelectorExpr:
+		if _, ok := e.X.(*ast.Ident); !ok {
+			return fmt.Errorf("qualified type must be of the form pkg.Name")
+		}
+		return nil
+	case *ast.ParenExpr:
+		return checkType(e.X)
+	case *ast.StarExpr:
+		return checkType(e.X)
+	case *ast.ArrayType:
+		if _, ok := e.Len.(*ast.Ellipsis); ok {
+			return fmt.Errorf("[...] array length is only allowed in composite literals")
+		}
+		return checkType(e.Elt)
+	case *ast.MapType:
+		if err := checkType(e.Key); err != nil {
+			return err
+		}
+		return checkType(e.Value)
+	case *ast.ChanType:
+		return checkType(e.Value)
+	case *ast.IndexExpr:
+		// instantiation of a generic type, like List[int]
+		if err := checkType(e.X); err != nil {
+			return err
+		}
+		return checkType(e.Index)
+	case *ast.IndexListExpr:
+		if err := checkType(e.X); err != nil {
+			return err
+		}
+		for _, index := range e.Indices {
+			if err := checkType(index); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *ast.FuncType, *ast.StructType, *ast.InterfaceType:
+		return nil
+	default:
+		return fmt.Errorf("not a type expression")
+	}
+}
+
+// PackageName derives a package name for a specialization of the
+// package pkg from its replacement for generic.T, keeping only the
+// letters and digits of the type:
+//
+//	list, int        ->  intlist
+//	list, *big.Int   ->  bigintlist
+//
+// The type is lowercased rather than title-cased, as package names
+// are by convention.  It's an error for lookup to have no generic.T.
+func PackageName(pkg string, lookup map[string]string) (string, error) {
+	typ, ok := lookup[genericTypes[0]]
+	if !ok {
+		return "", fmt.Errorf("no replacement for %s.%s to name the package after", genericPkg, genericTypes[0])
+	}
+	return typeWord(typ) + pkg, nil
+}
+
+// FileName substitutes replacement types into a file name wherever a
+// generic type appears as a "_T_" placeholder:
+//
+//	store_T_.go, int      ->  store_int.go
+//	store_T_test.go, int  ->  store_int_test.go
+//
+// A placeholder's closing underscore is dropped when the extension
+// follows it; any other "_.go" in the name is kept.
+func FileName(name string, lookup map[string]string) string {
+	for alias, typ := range lookup {
+		word := typeWord(typ)
+		if strings.HasSuffix(name, "_"+alias+"_.go") {
+			name = strings.TrimSuffix(name, alias+"_.go") + word + ".go"
+		}
+		name = strings.Replace(name, "_"+alias+"_", "_"+word+"_", -1)
+	}
+	return name
+}
+
+// typeWord reduces a type expression to its lowercased letters and
+// digits, for use in names.
+func typeWord(typ string) string {
+	var b strings.Builder
+	for _, r := range typ {
+		// names can't start with a digit, as in "[4]int"
+		if unicode.IsLetter(r) || (b.Len() > 0 && unicode.IsDigit(r)) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
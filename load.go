@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadPackage asks the go tool for the source files of the package
+// name, which works the same in module mode, GOPATH mode and with
+// vendoring.  Files are selected as "go build" would for the target
+// platform (taken from $GOOS and $GOARCH) and tags; with tests, the
+// package's _test.go files are included too, external test package
+// and all.  If version is set, the package has to come from that
+// version of its module.  It returns the package's directory and its
+// files, sorted.
+func loadPackage(name, version string, tags []string, tests bool) (string, []string, error) {
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles,
+		Tests: tests,
+	}
+	if len(tags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(tags, ",")}
+	}
+
+	pkgs, err := packages.Load(cfg, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return "", nil, pkg.Errors[0]
+		}
+		// the generated test main isn't part of the package
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue
+		}
+		for _, file := range pkg.GoFiles {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("%s: no Go files to convert", name)
+	}
+	if version != "" {
+		if err := checkVersion(name, version, cfg.BuildFlags); err != nil {
+			return "", nil, err
+		}
+	}
+	sort.Strings(files)
+
+	return filepath.Dir(files[0]), files, nil
+}
+
+// exactVersion matches a module version, as opposed to a query like
+// "latest" or "v1" that go get resolves to one.
+var exactVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+incompatible)?$`)
+
+// checkVersion returns an error unless the package name is loaded from
+// the given version of its module, since the go tool can't be asked to
+// load a package at a version itself.  A query, which go get resolves,
+// only has to be loaded from a module.
+func checkVersion(name, version string, buildFlags []string) error {
+	args := append([]string{"list", "-f", "{{with .Module}}{{.Version}}{{end}}"}, buildFlags...)
+	out, err := exec.Command("go", append(args, name)...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			return fmt.Errorf("%s@%s: %s", name, version, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return fmt.Errorf("%s@%s: %s", name, version, err)
+	}
+
+	got := strings.TrimSpace(string(out))
+	switch {
+	case got == "":
+		return fmt.Errorf("%s@%s: the package isn't loaded from a module version, so it can't be pinned to one", name, version)
+	case exactVersion.MatchString(version) && got != version:
+		return fmt.Errorf("%s@%s: the package is loaded from %s instead", name, version, got)
+	}
+	return nil
+}
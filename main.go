@@ -4,95 +4,422 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"syscall"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/joeshaw/gengen/genlib"
-	"golang.org/x/tools/imports"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// exitCode unwinds run with the code to exit with, so that its deferred
+// calls, like stopping a CPU profile, happen even when it dies.
+type exitCode int
+
+func run() (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, ok := r.(exitCode)
+			if !ok {
+				panic(r)
+			}
+			code = int(c)
+		}
+	}()
+
+	start := time.Now()
+
 	var (
-		outDir     = flag.String("o", ".", "output directory")
+		outDir     = flag.String("o", ".", "output directory, or archive file with -out-mode zip")
+		outMode    = flag.String("out-mode", "files", "write output as `mode`: files, stdout or zip")
 		fixImports = flag.Bool("i", true, "run go files through `goimports`")
+		docTypes   = flag.Bool("doc-types", false, "end the doc comments of types declared with generic types by naming the replacement types")
+		verbatim   = flag.Bool("keep-comments-verbatim", false, "leave the template's comments exactly as they are, overriding -comments and -doc-types")
+		cpuProfile = flag.String("cpuprofile", "", "write a CPU profile to `file`")
+		showDiff   = flag.Bool("diff", false, "print a diff against the existing output files instead of writing them")
+		keepTag    = flag.String("keepgeneric", "", "also write the unconverted files, choosing between the two versions with build `tag`")
+		showImps   = flag.Bool("imports", false, "print the imports of each converted file instead of writing them")
+		renameFile = flag.Bool("replace-in-filenames", false, "substitute replacement types for _T_, _U_ and _V_ in output file names")
+		split      = flag.Bool("split", false, "write each top-level type and its methods to a file of its own")
+		verbose    = flag.Bool("v", false, "report substitutions and import changes for each file")
+		force      = flag.Bool("force", false, "regenerate even if the inputs haven't changed since the last run")
+		summary    = flag.Bool("summary", false, "report totals for the whole run when it's done")
+		offline    = flag.Bool("offline", false, "don't run go get; the package must already be available locally")
+		selfTest   = flag.Bool("selftest", false, "generate and build each bundled example to check gengen works, then exit")
+		serveAddr  = flag.String("serve", "", "serve conversions over HTTP on `addr` instead of converting a package")
+		listFiles  = flag.Bool("list-files", false, "print the source files that would be converted and exit")
+		validate   = flag.Bool("validate", false, "check the replacement types and exit without generating anything")
+		formatter  = flag.String("fmt", "", "format output with the external `command`, such as gofmt, instead of the built-in formatter")
+		pkgPrefix  = flag.String("package-prefix", "", "prefix the output package name and the last element of the output directory with `prefix`")
+		nameFromTs = flag.Bool("name-from-replacements", false, "name the output package after its replacement for generic.T, as in intlist")
+		maxLine    = flag.Int("max-line-length", 0, "warn about converted lines longer than `n` characters")
+		genericPkg = flag.String("genericpkg", "", "import `path` of the package defining the generic types, if not github.com/joeshaw/gengen/generic")
+		inTags     = flag.Bool("replace-in-tags", false, "replace {{T}}, {{U}} and {{V}} in struct tags with the replacement types")
+		inStrings  = flag.Bool("replace-in-strings", false, "replace {{T}}, {{U}} and {{V}} in string literals with the replacement types")
+		comments   = flag.Bool("comments", false, "replace generic types mentioned in comments, as in \"returns the generic.T\", too")
+		lineDirs   = flag.Bool("line", false, "add //line directives so compiler errors point at the template's lines")
+		strict     = flag.Bool("strict-unused", false, "fail instead of warning when a replacement type is never used")
+		keepUnmap  = flag.Bool("keep-unmapped", false, "leave generic types without a replacement as they are, with a warning, instead of failing")
+		keepGoing  = flag.Bool("keep-going-on-format-errors", false, "write files that fail to format as they are, with a warning, instead of stopping")
+		typeTable  = flag.Bool("substitution-comment", false, "list the replacement types in a comment at the top of each converted file")
+		typeTags   = flag.String("type-tags", "", "replace __TYPE__ with the field's type in the values of these comma-separated struct tag `keys`")
+		license    = flag.String("license", "", "prepend the contents of `file` to each converted file as a license header")
+		buildTags  = flag.String("tags", "", "convert the files that build with these comma-separated build `tags`")
+		goos       = flag.String("goos", "", "target operating system, instead of $GOOS")
+		goarch     = flag.String("goarch", "", "target architecture, instead of $GOARCH")
+		tests      = flag.Bool("tests", true, "convert the package's _test.go files too")
 	)
+	var overrides overrideFlag
+	var importMap importFlag
+	flag.Var(&importMap, "import", "import the package `name=path` used in replacement types, or name=path as alias to import it under another name; may be repeated")
+	flag.Var(&overrides, "override", "replace a generic type differently in files matching a pattern, as in `'*_keyed.go:T=string'`; may be repeated")
 	flag.Parse()
 
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			die(err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			die(err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	// the target platform applies to everything consulting the build
+	// context, including goimports and the go commands we run
+	if *goos != "" {
+		build.Default.GOOS = *goos
+		os.Setenv("GOOS", *goos)
+	}
+	if *goarch != "" {
+		build.Default.GOARCH = *goarch
+		os.Setenv("GOARCH", *goarch)
+	}
+
+	opts := genlib.Options{
+		FixImports:           *fixImports,
+		DocTypes:             *docTypes,
+		KeepCommentsVerbatim: *verbatim,
+		Formatter:            *formatter,
+		SubstitutionComment:  *typeTable,
+		KeepUnformatted:      *keepGoing,
+		KeepUnmapped:         *keepUnmap,
+		FailOnUnused:         *strict,
+		GenericPackage:       *genericPkg,
+		LineDirectives:       *lineDirs,
+		ReplaceInComments:    *comments,
+		ReplaceInTags:        *inTags,
+		ReplaceInStrings:     *inStrings,
+		MaxLineLength:        *maxLine,
+	}
+
+	if *license != "" {
+		text, err := ioutil.ReadFile(*license)
+		if err != nil {
+			die(err)
+		}
+		opts.LicenseHeader = string(text)
+	}
+	if *keepTag != "" {
+		opts.OutputBuildTags = []string{*keepTag}
+	}
+	opts.TypeTagKeys = splitTags(*typeTags)
+	opts.TypeOverrides = overrides
+	opts.Imports = importMap
+
+	// the server converts single files, like reading from stdin
+	if *serveAddr != "" {
+		rejectFlags("with -serve", "o", "out-mode", "diff", "imports", "split", "keepgeneric",
+			"list-files", "replace-in-filenames", "name-from-replacements", "package-prefix",
+			"force", "tags", "tests", "offline", "selftest", "validate", "v", "summary")
+		die(serve(*serveAddr, opts))
+	}
+
+	// "-" converts a single file read from stdin, so flags for
+	// packages, or for gengen's own reports, don't apply
+	if flag.Arg(0) == "-" {
+		rejectFlags("when reading from stdin; the output goes to stdout", "o", "out-mode", "diff",
+			"imports", "split", "keepgeneric", "list-files", "replace-in-filenames",
+			"name-from-replacements", "package-prefix", "force", "tags", "goos", "goarch",
+			"tests", "override", "offline", "v", "summary", "validate", "selftest")
+	}
+
+	if *selfTest {
+		if !selftest(genlib.Options{FixImports: *fixImports, Formatter: *formatter}) {
+			return 1
+		}
+		return 0
+	}
+
 	if flag.NArg() < 2 {
 		cmd := os.Args[0]
-		fmt.Fprintf(os.Stderr, "usage: %s [-o <output_dir>] <package> <replacement types...>\n", cmd)
+		fmt.Fprintf(os.Stderr, "usage: %s [-o <output_dir>] <package>[@<version>] <replacement types...>\n", cmd)
+		fmt.Fprintf(os.Stderr, "       %s - <replacement types...> < file.go > out.go\n", cmd)
+		fmt.Fprintf(os.Stderr, "replacement types fill generic.T, U and V in order, or name one, as in U=string\n")
 		fmt.Fprintf(os.Stderr, "example: %s -o ./btree github.com/joeshaw/gengen/examples/btree string string\n", cmd)
-		os.Exit(1)
+		return 1
 	}
 
-	types := make([]string, flag.NArg()-1)
-	for i := 1; i < flag.NArg(); i++ {
-		types[i-1] = flag.Arg(i)
+	// every problem with the arguments is reported, not just the first
+	if *validate {
+		errs := genlib.CheckArgs(flag.Args()[1:])
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		}
+		if len(errs) > 0 {
+			return 1
+		}
+		return 0
 	}
 
-	// run a "go get <pkg>"
-	err := exec.Command("go", "get", flag.Arg(0)).Run()
+	types, err := genlib.ParseArgs(flag.Args()[1:])
 	if err != nil {
 		die(err)
 	}
 
-	// resolve the path into which we (might have) just installed it
-	pkgPath := findPkgPath(flag.Arg(0))
-	if pkgPath == "" {
-		die(fmt.Errorf("couldn't find %s", flag.Arg(0)))
+	if flag.Arg(0) == "-" {
+		generateStdin(&opts, types)
+		return 0
 	}
 
-	// list the source files
-	sourceFiles, err := filepath.Glob(filepath.Join(pkgPath, "*.go"))
+	// a "<pkg>@<version>" argument pins the template to a module
+	// version: go get selects it, and loading the package checks
+	// that's the version found
+	pkgName, version := splitVersion(flag.Arg(0))
+
+	// run a "go get <pkg>", or make sure nothing else is downloaded
+	if *offline {
+		if version != "" && !exactVersion.MatchString(version) {
+			die(fmt.Errorf("-offline needs an exact version, like v1.2.3, not %s", version))
+		}
+		os.Setenv("GOPROXY", "off")
+	} else {
+		err = exec.Command("go", "get", flag.Arg(0)).Run()
+		if err != nil {
+			die(err)
+		}
+	}
+
+	// list the source files the package builds with
+	_, sourceFiles, err := loadPackage(pkgName, version, splitTags(*buildTags), *tests)
 	if err != nil {
+		if *offline {
+			die(fmt.Errorf("%s; with -offline the package must already be in the module cache, vendored or in GOPATH, so fetch it first with go get", err))
+		}
 		die(err)
 	}
 
-	// create a temporary directory
-	tempDir, err := ioutil.TempDir("", "")
+	if *listFiles {
+		for _, file := range sourceFiles {
+			fmt.Println(file)
+		}
+		return 0
+	}
+
+	// the package clause is only rewritten if asked to
+	var outPkg string
+	if *nameFromTs || *pkgPrefix != "" {
+		if *keepTag != "" {
+			die(fmt.Errorf("-name-from-replacements and -package-prefix can't be used with -keepgeneric"))
+		}
+		outPkg, err = packageName(sourceFiles)
+		if err != nil {
+			die(err)
+		}
+		if *nameFromTs {
+			if outPkg, err = genlib.PackageName(outPkg, types); err != nil {
+				die(err)
+			}
+		}
+		outPkg = *pkgPrefix + outPkg
+		if !token.IsIdentifier(outPkg) {
+			die(fmt.Errorf("%q is not a valid package name", outPkg))
+		}
+	}
+
+	// the output directory takes the prefix too, as in -o ./btree
+	// becoming ./gen_btree
+	if base := filepath.Base(*outDir); *pkgPrefix != "" && *outMode == "files" && base != "." && base != ".." {
+		*outDir = filepath.Join(filepath.Dir(*outDir), *pkgPrefix+base)
+	}
+
+	out, err := newOutputWriter(*outMode, *outDir)
 	if err != nil {
 		die(err)
 	}
 
-	// convert all source files into the tmp dir
-	for _, sourcePath := range sourceFiles {
-		destPath := filepath.Join(tempDir, filepath.Base(sourcePath))
-		err := convertFile(destPath, sourcePath, *fixImports, types...)
+	// skip the run if nothing has changed since the last one
+	useCache := *outMode == "files" && !*showDiff && !*showImps
+	var hash string
+	if useCache {
+		hash, err = inputHash(sourceFiles, types, *license)
 		if err != nil {
 			die(err)
 		}
+		if !*force && upToDate(*outDir, flag.Arg(0), hash) {
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "%s: up to date\n", flag.Arg(0))
+			}
+			return 0
+		}
 	}
 
-	// move the converted files into our output dir
-	replaceFiles(tempDir, *outDir)
+	opts.PackageName = outPkg
 
-	// remove the temporary directory
-	os.RemoveAll(tempDir)
+	converted, err := opts.GeneratePackage(sourceFiles, types)
+	if err != nil {
+		die(err)
+	}
+	if unused := genlib.UnusedKeys(converted); len(unused) > 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", genlib.UnusedError(types, unused))
+	}
+
+	// collect everything in memory before writing anything
+	var outputs []outputFile
+	written := make(map[string]bool)
+	for _, file := range converted {
+		sourcePath := file.Name
+		for _, w := range file.Warnings {
+			fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+		}
+		if *verbose {
+			printStats(file)
+		}
+
+		parts := map[string][]byte{filepath.Base(sourcePath): file.Source}
+		if *split {
+			parts, err = genlib.Split(sourcePath, file.Source)
+			if err != nil {
+				die(err)
+			}
+		}
+
+		if *renameFile {
+			renamed := make(map[string][]byte, len(parts))
+			for name, src := range parts {
+				renamed[genlib.FileName(name, types)] = src
+			}
+			parts = renamed
+		}
+
+		// the unconverted file is built when the tag isn't set
+		if *keepTag != "" {
+			buf, err := tagFile(sourcePath, opts.LicenseHeader, "!"+*keepTag)
+			if err != nil {
+				die(err)
+			}
+			parts[genericName(filepath.Base(sourcePath))] = buf
+		}
+
+		names := make([]string, 0, len(parts))
+		for name := range parts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if written[name] {
+				die(fmt.Errorf("%s: more than one file would be written to %s", sourcePath, name))
+			}
+			written[name] = true
+			outputs = append(outputs, outputFile{name: name, src: parts[name]})
+		}
+	}
+
+	if *showImps {
+		printImports(outputs)
+	} else if *showDiff {
+		diffFiles(outputs, *outDir)
+	} else if err := out.writeFiles(outputs); err != nil {
+		die(err)
+	}
+
+	if useCache {
+		if err := updateCache(*outDir, flag.Arg(0), hash, outputs); err != nil {
+			die(err)
+		}
+	}
+
+	if *summary {
+		printSummary(converted, time.Since(start))
+	}
+	return 0
 }
 
-func convertFile(destPath, sourcePath string, fixImports bool, types ...string) error {
-	buf, err := genlib.Generate(sourcePath, types...)
+// generateStdin converts the source read from stdin and writes it to
+// stdout.
+func generateStdin(opts *genlib.Options, types map[string]string) {
+	file, err := opts.GenerateFile("stdin.go", os.Stdin, types)
 	if err != nil {
-		return err
+		die(err)
+	}
+	if unused := genlib.UnusedKeys([]*genlib.File{file}); len(unused) > 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", genlib.UnusedError(types, unused))
 	}
+	for _, w := range file.Warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+	}
+	os.Stdout.Write(file.Source)
+}
 
-	if fixImports {
-		buf, err = imports.Process(filepath.Base(sourcePath), buf, &imports.Options{
-			TabWidth:  8,
-			TabIndent: true,
-			Comments:  true,
-			Fragment:  true,
-			AllErrors: false,
-		})
-		if err != nil {
-			return err
+// rejectFlags dies if any of the named flags was set on the command
+// line, explaining they can't be used how.
+func rejectFlags(how string, names ...string) {
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				die(fmt.Errorf("-%s can't be used %s", f.Name, how))
+			}
 		}
+	})
+}
+
+// tagFile returns the source file unconverted, guarded by a build
+// constraint requiring tags and headed by license if it's set.
+func tagFile(sourcePath, license string, tags ...string) ([]byte, error) {
+	src, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := genlib.AddBuildTags(src, tags...)
+	if err != nil {
+		return nil, err
 	}
 
+	if license != "" {
+		if buf, err = genlib.AddLicense(buf, license); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+// genericName returns the file name used for the unconverted copy of
+// a source file, keeping test files recognizable as such.
+func genericName(name string) string {
+	if strings.HasSuffix(name, "_test.go") {
+		return strings.TrimSuffix(name, "_test.go") + "_generic_test.go"
+	}
+	return strings.TrimSuffix(name, ".go") + "_generic.go"
+}
+
+func writeFile(destPath string, buf []byte) error {
 	f, err := os.Create(destPath)
 	if err != nil {
 		return err
@@ -107,84 +434,205 @@ func convertFile(destPath, sourcePath string, fixImports bool, types ...string)
 	return f.Close()
 }
 
-func replaceFiles(sourceDir, destDir string) {
-	sources, err := filepath.Glob(filepath.Join(sourceDir, "*.go"))
-	if err != nil {
-		die(err)
-	}
+func diffFiles(files []outputFile, destDir string) {
+	for _, file := range files {
+		dest := filepath.Join(destDir, file.name)
 
-	if !exists(destDir) {
-		err := os.MkdirAll(destDir, 0755)
-		if err != nil {
+		// a missing output file diffs as empty
+		existing, err := ioutil.ReadFile(dest)
+		oldName := dest
+		if os.IsNotExist(err) {
+			oldName = "/dev/null"
+		} else if err != nil {
 			die(err)
 		}
+
+		os.Stdout.Write(unifiedDiff(oldName, dest, existing, file.src))
 	}
+}
 
-	for _, source := range sources {
-		dest := filepath.Join(destDir, filepath.Base(source))
+// printStats writes a line like
+//
+//	list.go: 4 substitutions +time -github.com/joeshaw/gengen/generic
+//
+// to stderr.
+func printStats(file *genlib.File) {
+	line := fmt.Sprintf("%s: %d substitutions", filepath.Base(file.Name), file.Stats.Substitutions)
+	for _, path := range file.Stats.ImportsAdded {
+		line += " +" + path
+	}
+	for _, path := range file.Stats.ImportsRemoved {
+		line += " -" + path
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
 
-		// attempt a simple rename
-		err := os.Rename(source, dest)
-		if err == nil {
-			continue
-		}
+// printSummary writes a line like
+//
+//	3 files: 12 substitutions, 1 import added, 3 removed in 45ms
+//
+// to stderr.
+func printSummary(files []*genlib.File, elapsed time.Duration) {
+	var total genlib.Stats
+	for _, file := range files {
+		total.Substitutions += file.Stats.Substitutions
+		total.ImportsAdded = append(total.ImportsAdded, file.Stats.ImportsAdded...)
+		total.ImportsRemoved = append(total.ImportsRemoved, file.Stats.ImportsRemoved...)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s, %s added, %d removed in %s\n",
+		plural(len(files), "file"), plural(total.Substitutions, "substitution"),
+		plural(len(total.ImportsAdded), "import"), len(total.ImportsRemoved), elapsed.Round(time.Millisecond))
+}
+
+// plural returns a count followed by word, pluralized if need be.
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
 
-		// /tmp is often a ramdisk so check for EXDEV
-		linkerr, ok := err.(*os.LinkError)
-		if !ok {
+func printImports(files []outputFile) {
+	for _, file := range files {
+		f, err := parser.ParseFile(token.NewFileSet(), file.name, file.src, parser.ImportsOnly)
+		if err != nil {
 			die(err)
 		}
-		errno, ok := linkerr.Err.(syscall.Errno)
-		if !ok {
-			die(err)
+
+		fmt.Printf("%s:\n", file.name)
+		for _, imp := range f.Imports {
+			if imp.Name != nil {
+				fmt.Printf("\t%s %s\n", imp.Name.Name, imp.Path.Value)
+			} else {
+				fmt.Printf("\t%s\n", imp.Path.Value)
+			}
 		}
-		if errno != syscall.EXDEV {
-			die(err)
+	}
+}
+
+// packageName returns the name of the package made up of files,
+// ignoring any external test package.
+func packageName(files []string) (string, error) {
+	for _, file := range files {
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+		if err != nil {
+			return "", err
+		}
+		if name := f.Name.Name; !strings.HasSuffix(name, "_test") {
+			if name == "main" {
+				return "", fmt.Errorf("%s: can't rename package main", file)
+			}
+			return name, nil
 		}
+	}
+	return "", fmt.Errorf("no package files to name")
+}
 
-		// have to copy the bytes explicitly
-		if err = copyBytes(source, dest); err != nil {
-			die(err)
+// overrideFlag collects -override flags.
+type overrideFlag []genlib.TypeOverride
+
+func (f *overrideFlag) String() string {
+	var args []string
+	for _, ov := range *f {
+		aliases := make([]string, 0, len(ov.Types))
+		for alias := range ov.Types {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			args = append(args, ov.Pattern+":"+alias+"="+ov.Types[alias])
 		}
 	}
+	return strings.Join(args, " ")
 }
 
-func copyBytes(source, dest string) error {
-	sfile, err := os.Open(source)
-	if err != nil {
+func (f *overrideFlag) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return fmt.Errorf("want <pattern>:<generic type>=<type>")
+	}
+	pattern, arg := s[:i], s[i+1:]
+	if _, err := filepath.Match(pattern, ""); err != nil {
 		return err
 	}
-	defer sfile.Close()
 
-	dfile, err := os.Create(dest)
-	if err != nil {
-		return err
+	j := strings.Index(arg, "=")
+	if j <= 0 {
+		return fmt.Errorf("want <pattern>:<generic type>=<type>")
 	}
 
-	if _, err = io.Copy(dfile, sfile); err != nil {
-		dfile.Close()
-		return err
+	// merge into the previous flag for the same pattern so the
+	// order of precedence follows the command line
+	if n := len(*f); n > 0 && (*f)[n-1].Pattern == pattern {
+		(*f)[n-1].Types[arg[:j]] = arg[j+1:]
+		return nil
+	}
+	*f = append(*f, genlib.TypeOverride{
+		Pattern: pattern,
+		Types:   map[string]string{arg[:j]: arg[j+1:]},
+	})
+	return nil
+}
+
+// importFlag collects -import flags.
+type importFlag []genlib.Import
+
+func (f *importFlag) String() string {
+	var args []string
+	for _, imp := range *f {
+		arg := imp.Name + "=" + imp.Path
+		if imp.Alias != "" {
+			arg += " as " + imp.Alias
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, ", ")
+}
+
+func (f *importFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("want <name>=<path> [as <alias>]")
 	}
 
-	return dfile.Close()
+	imp := genlib.Import{Name: s[:i]}
+	fields := strings.Fields(s[i+1:])
+	switch {
+	case len(fields) == 1:
+		imp.Path = fields[0]
+	case len(fields) == 3 && fields[1] == "as":
+		imp.Path, imp.Alias = fields[0], fields[2]
+	default:
+		return fmt.Errorf("want <name>=<path> [as <alias>]")
+	}
+	if !token.IsIdentifier(imp.Name) || (imp.Alias != "" && !token.IsIdentifier(imp.Alias)) {
+		return fmt.Errorf("package names must be identifiers")
+	}
+
+	*f = append(*f, imp)
+	return nil
 }
 
-func findPkgPath(name string) string {
-	for _, dir := range filepath.SplitList(os.Getenv("GOPATH")) {
-		fullPath := filepath.Join(dir, "src", name)
-		if exists(fullPath) {
-			return fullPath
+func splitTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
 		}
 	}
-	return ""
+	return tags
 }
 
-func exists(fpath string) bool {
-	_, err := os.Stat(fpath)
-	return !os.IsNotExist(err)
+func splitVersion(arg string) (name, version string) {
+	i := strings.LastIndex(arg, "@")
+	if i < 0 {
+		return arg, ""
+	}
+	return arg[:i], arg[i+1:]
 }
 
 func die(err error) {
 	fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-	os.Exit(1)
+	panic(exitCode(1))
 }
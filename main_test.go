@@ -0,0 +1,569 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/joeshaw/gengen/genlib"
+)
+
+const examplesPath = "github.com/joeshaw/gengen/examples/"
+
+// TestMain runs gengen itself, instead of the tests, in the
+// subprocesses the tests start with runGengen.
+func TestMain(m *testing.M) {
+	if os.Getenv("GENGEN_TEST_RUN_MAIN") != "" {
+		os.Exit(run())
+	}
+	os.Exit(m.Run())
+}
+
+// runGengen runs gengen with args and returns its output.
+func runGengen(t *testing.T, args ...string) []byte {
+	t.Helper()
+	return runGengenStdin(t, "", args...)
+}
+
+// runGengenStdin runs gengen with args and stdin as its input, and
+// returns its output.
+func runGengenStdin(t *testing.T, stdin string, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GENGEN_TEST_RUN_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("gengen %v: %s\n%s", args, err, stderr.Bytes())
+	}
+	return out
+}
+
+// runGengenStderr runs gengen with args and returns what it wrote to
+// stderr.
+func runGengenStderr(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GENGEN_TEST_RUN_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("gengen %v: %s\n%s", args, err, stderr.Bytes())
+	}
+	return stderr.String()
+}
+
+// runGengenFailing runs gengen with args, expecting it to fail, and
+// returns what it wrote to stderr.
+func runGengenFailing(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GENGEN_TEST_RUN_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("gengen %v succeeded", args)
+	}
+	return stderr.String()
+}
+
+// The library converts the examples byte for byte like the command
+// does, given the same options.
+func TestLibraryMatchesCommand(t *testing.T) {
+	for _, st := range selftests {
+		t.Run(st.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := []string{"-o", dir, examplesPath + st.name}
+			aliases := make([]string, 0, len(st.types))
+			for alias := range st.types {
+				aliases = append(aliases, alias)
+			}
+			sort.Strings(aliases)
+			for _, alias := range aliases {
+				args = append(args, alias+"="+st.types[alias])
+			}
+			runGengen(t, args...)
+
+			_, files, err := loadPackage(examplesPath+st.name, "", nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := genlib.Options{FixImports: true}
+			for _, file := range files {
+				want, err := opts.Generate(file, st.types)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadFile(filepath.Join(dir, filepath.Base(file)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s: gengen wrote:\n%s\ngenlib.Generate returned:\n%s", filepath.Base(file), got, want)
+				}
+			}
+		})
+	}
+}
+
+// -package-prefix prefixes the package named by -name-from-replacements
+// and the output directory.
+func TestPackageNaming(t *testing.T) {
+	dir := t.TempDir()
+	runGengen(t, "-name-from-replacements", "-package-prefix", "gen_", "-o", filepath.Join(dir, "btree"), examplesPath+"btree", "*big.Int", "string")
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filepath.Join(dir, "gen_btree", "btree.go"), nil, parser.PackageClauseOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Name.Name, "gen_bigintbtree"; got != want {
+		t.Errorf("got package %s, want %s", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "btree")); !os.IsNotExist(err) {
+		t.Errorf("unprefixed output directory written: %v", err)
+	}
+}
+
+// -list-files prints the files that would be converted without
+// converting them.
+func TestListFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "btree")
+	out := runGengen(t, "-list-files", "-o", dir, examplesPath+"btree", "int", "string")
+
+	files := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(files) != 1 || !strings.HasSuffix(files[0], filepath.Join("examples", "btree", "btree.go")) {
+		t.Errorf("got files %q, want just examples/btree/btree.go", files)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("output directory written: %v", err)
+	}
+}
+
+// -summary reports the totals for the run.
+func TestSummary(t *testing.T) {
+	stderr := runGengenStderr(t, "-summary", "-o", t.TempDir(), examplesPath+"list", "time.Duration")
+	if want := "1 file: 5 substitutions, 1 import added, 1 removed in "; !strings.HasPrefix(stderr, want) {
+		t.Errorf("got summary %q, want one starting with %q", stderr, want)
+	}
+}
+
+// -v reports each file's Stats.
+func TestVerboseStats(t *testing.T) {
+	stderr := runGengenStderr(t, "-v", "-o", t.TempDir(), examplesPath+"list", "time.Duration")
+	if want := "list.go: 5 substitutions +time -github.com/joeshaw/gengen/generic\n"; stderr != want {
+		t.Errorf("got %q, want %q", stderr, want)
+	}
+}
+
+// Warnings about a conversion the server still made come back in
+// headers.
+func TestServeWarnings(t *testing.T) {
+	body := `{"name": "pair.go", "source": "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype Pair struct {\n\tA generic.T\n\tB generic.U\n}\n", "types": {"T": "int", "V": "bool"}}`
+	req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	generateHandler(genlib.Options{KeepUnmapped: true}).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	want := []string{
+		"substitution V=bool was never used",
+		"pair.go:7:4: generic.U used but no substitution given",
+	}
+	if got := rec.Header().Values(warningHeader); !reflect.DeepEqual(got, want) {
+		t.Errorf("got warnings %q, want %q", got, want)
+	}
+}
+
+// Flags that only make sense when converting a package are rejected
+// instead of being ignored by the server.
+func TestServeRejectsPackageFlags(t *testing.T) {
+	for _, flag := range []string{"-split", "-keepgeneric=generic", "-summary"} {
+		stderr := runGengenFailing(t, "-serve", "127.0.0.1:0", flag)
+		if name := strings.SplitN(flag, "=", 2)[0]; !strings.Contains(stderr, name+" can't be used with -serve") {
+			t.Errorf("gengen -serve %s: got %q", flag, stderr)
+		}
+	}
+}
+
+// exampleFiles has to be regenerated when an example changes.
+func TestExampleFilesCurrent(t *testing.T) {
+	onDisk := make(map[string]string)
+	err := filepath.Walk("examples", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel("examples", path)
+		if err != nil {
+			return err
+		}
+		onDisk[filepath.ToSlash(rel)] = string(src)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exampleFiles, onDisk) {
+		t.Error("examples.go is out of date; run go generate")
+	}
+}
+
+// -selftest doesn't need gengen's module, or GOPATH, to find the
+// examples.
+func TestSelftestOutsideModule(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-selftest")
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "GENGEN_TEST_RUN_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("gengen -selftest: %s\n%s", err, out)
+	}
+	for _, st := range selftests {
+		if want := st.name + ": ok\n"; !strings.Contains(string(out), want) {
+			t.Errorf("got:\n%s\nwant a line %q", out, want)
+		}
+	}
+}
+
+// templateModule sets up a module proxy serving example.com/tmpl,
+// whose generic package is example.com/tmpl/generic, and a module
+// cache for it.  It returns the environment to run the go tool with,
+// an empty module to run it in and the module cache.
+func templateModule(t *testing.T) (env []string, work, modCache string) {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip(err)
+	}
+
+	// serve testdata/tmplmod as example.com/tmpl v1.0.0 and v1.1.0
+	// from a file proxy
+	proxy := t.TempDir()
+	zipDir := filepath.Join(proxy, "example.com", "tmpl", "@v")
+	if err := os.MkdirAll(zipDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		moduleVersion(t, zipDir, version)
+	}
+
+	// the module cache is left read-only, as it is by default
+	modCache = t.TempDir()
+	env = append(os.Environ(), "GOMODCACHE="+modCache, "GOPROXY=file://"+filepath.ToSlash(proxy),
+		"GOSUMDB=off", "GOFLAGS=-modcacherw=false", "GO111MODULE=on")
+	t.Cleanup(func() {
+		cmd := exec.Command("go", "clean", "-modcache")
+		cmd.Env = env
+		cmd.Run()
+	})
+
+	work = t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(work, "go.mod"), []byte("module work\n\ngo 1.12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return env, work, modCache
+}
+
+// moduleVersion writes testdata/tmplmod to the proxy directory dir as
+// the given version.
+func moduleVersion(t *testing.T, dir, version string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := filepath.Walk(filepath.Join("testdata", "tmplmod"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Join("testdata", "tmplmod"), path)
+		if err != nil {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create("example.com/tmpl@" + version + "/" + filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(src)
+		return err
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := ioutil.ReadFile(filepath.Join("testdata", "tmplmod", "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"list":            []byte("v1.0.0\nv1.1.0\n"),
+		version + ".info": []byte(`{"Version": "` + version + `"}`),
+		version + ".mod":  mod,
+		version + ".zip":  buf.Bytes(),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A template in the module cache, which is read-only, is found and
+// converted without writing next to it.
+func TestModuleCacheTemplate(t *testing.T) {
+	env, work, modCache := templateModule(t)
+
+	out := filepath.Join(work, "stack")
+	cmd := exec.Command(os.Args[0], "-o", out, "-genericpkg", "example.com/tmpl/generic", "example.com/tmpl@v1.0.0", "int")
+	cmd.Dir = work
+	cmd.Env = append(env, "GENGEN_TEST_RUN_MAIN=1")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("gengen: %s\n%s", err, msg)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(out, "stack.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte("type Stack []int")) {
+		t.Errorf("got:\n%s", got)
+	}
+	info, err := os.Stat(filepath.Join(modCache, "example.com", "tmpl@v1.0.0"))
+	if err != nil {
+		t.Fatalf("template not in the module cache: %v", err)
+	}
+	if info.Mode().Perm()&0222 != 0 {
+		t.Errorf("module cache directory is writable: %v", info.Mode())
+	}
+}
+
+// -validate prints every problem with the arguments before failing.
+func TestValidateReportsAll(t *testing.T) {
+	stderr := runGengenFailing(t, "-validate", examplesPath+"list", "W=int", "T=map[int")
+	for _, want := range []string{"generic.W is not a generic type", "generic.T: "} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("got:\n%s\nwant an error containing %q", stderr, want)
+		}
+	}
+}
+
+// Nothing is written to the output directory unless every file can be,
+// and the temporary directory doesn't stay behind.
+func TestDirWriter(t *testing.T) {
+	dir := t.TempDir()
+	w := dirWriter{dir: dir}
+
+	bad := []outputFile{{name: "a.go", src: []byte("package a\n")}, {name: "missing/b.go", src: []byte("package a\n")}}
+	if err := w.writeFiles(bad); err == nil {
+		t.Fatal("writing into a missing directory succeeded")
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("failed write left %d entries behind", len(entries))
+	}
+
+	good := []outputFile{{name: "a.go", src: []byte("package a\n")}, {name: "b.go", src: []byte("package a\n")}}
+	if err := w.writeFiles(good); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"a.go", "b.go"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+// A package is only up to date while the files written for it are
+// there and unchanged.
+func TestUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	files := []outputFile{{name: "list.go", src: []byte("package list\n")}}
+	if err := (dirWriter{dir: dir}).writeFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateCache(dir, "example.com/list", "abc", files); err != nil {
+		t.Fatal(err)
+	}
+
+	if !upToDate(dir, "example.com/list", "abc") {
+		t.Error("not up to date after the run")
+	}
+	if upToDate(dir, "example.com/list", "def") {
+		t.Error("up to date with other inputs")
+	}
+	if upToDate(dir, "example.com/other", "abc") {
+		t.Error("another package is up to date")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "list.go"), []byte("package list // edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if upToDate(dir, "example.com/list", "abc") {
+		t.Error("up to date after editing the output")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "list.go")); err != nil {
+		t.Fatal(err)
+	}
+	if upToDate(dir, "example.com/list", "abc") {
+		t.Error("up to date after removing the output")
+	}
+}
+
+// -type-tags names the struct tag keys to fill in field types for.
+func TestTypeTagsFlag(t *testing.T) {
+	src := "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype Row struct {\n\tValue generic.T `schema:\"__TYPE__\" db:\"__TYPE__\"`\n}\n"
+	out := runGengenStdin(t, src, "-type-tags", "schema, other", "-", "int64")
+	if want := "Value int64 `schema:\"int64\" db:\"__TYPE__\"`"; !strings.Contains(string(out), want) {
+		t.Errorf("got:\n%s\nwant a line containing %s", out, want)
+	}
+}
+
+// lines returns the numbers from 1 to n on lines of their own, with
+// the ones in changed spelled out instead.
+func lines(n int, changed map[int]string) string {
+	var buf strings.Builder
+	for i := 1; i <= n; i++ {
+		if word, ok := changed[i]; ok {
+			buf.WriteString(word + "\n")
+		} else {
+			fmt.Fprintf(&buf, "%d\n", i)
+		}
+	}
+	return buf.String()
+}
+
+// The diffs match what diff -u prints after the file names.
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "identical",
+			a:    lines(3, nil),
+			b:    lines(3, nil),
+		},
+		{
+			name: "change with context",
+			a:    lines(10, nil),
+			b:    lines(10, map[int]string{5: "five"}),
+			want: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate hunks",
+			a:    lines(20, nil),
+			b:    lines(20, map[int]string{2: "two", 18: "eighteen"}),
+			want: "@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			name: "changes close enough to share a hunk",
+			a:    lines(12, nil),
+			b:    lines(12, map[int]string{2: "two", 9: "nine"}),
+			want: "@@ -1,12 +1,12 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n",
+		},
+		{
+			name: "lines added at the end",
+			a:    lines(10, nil),
+			b:    lines(12, nil),
+			want: "@@ -8,3 +8,5 @@\n 8\n 9\n 10\n+11\n+12\n",
+		},
+		{
+			name: "new file",
+			a:    "",
+			b:    "a\nb\n",
+			want: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "no newline at end of file",
+			a:    "x\ny",
+			b:    "x\nz\n",
+			want: "@@ -1,2 +1,2 @@\n x\n-y\n\\ No newline at end of file\n+z\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(unifiedDiff("a.go", "b.go", []byte(tt.a), []byte(tt.b)))
+			want := ""
+			if tt.want != "" {
+				want = "--- a.go\n+++ b.go\n" + tt.want
+			}
+			if got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// "-" converts stdin to stdout, and flags that would have no effect
+// are rejected.
+func TestStdin(t *testing.T) {
+	src := "package p\n\nimport \"github.com/joeshaw/gengen/generic\"\n\ntype List []generic.T\n"
+	if got, want := string(runGengenStdin(t, src, "-", "int")), "package p\n\ntype List []int\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, flag := range []string{"-v", "-summary", "-validate", "-selftest", "-diff", "-o=out", "-split"} {
+		stderr := runGengenFailing(t, flag, "-", "int")
+		if name := strings.SplitN(flag, "=", 2)[0]; !strings.Contains(stderr, name+" can't be used when reading from stdin") {
+			t.Errorf("gengen %s -: got %q", flag, stderr)
+		}
+	}
+}
+
+// A version after the package path has to be the one loaded.
+func TestPinnedVersion(t *testing.T) {
+	env, work, _ := templateModule(t)
+	gengen := func(args ...string) ([]byte, error) {
+		args = append([]string{"-o", filepath.Join(work, "stack"), "-genericpkg", "example.com/tmpl/generic"}, args...)
+		cmd := exec.Command(os.Args[0], append(args, "int")...)
+		cmd.Dir = work
+		cmd.Env = append(env, "GENGEN_TEST_RUN_MAIN=1")
+		return cmd.CombinedOutput()
+	}
+
+	if msg, err := gengen("example.com/tmpl@v1.0.0"); err != nil {
+		t.Fatalf("gengen: %s\n%s", err, msg)
+	}
+
+	// without go get, the module still requires v1.0.0
+	msg, err := gengen("-offline", "-force", "example.com/tmpl@v1.1.0")
+	if want := "example.com/tmpl@v1.1.0: the package is loaded from v1.0.0 instead"; err == nil || !bytes.Contains(msg, []byte(want)) {
+		t.Errorf("got %v:\n%s\nwant an error containing %q", err, msg, want)
+	}
+	msg, err = gengen("-offline", "-force", "example.com/tmpl@latest")
+	if want := "-offline needs an exact version"; err == nil || !bytes.Contains(msg, []byte(want)) {
+		t.Errorf("got %v:\n%s\nwant an error containing %q", err, msg, want)
+	}
+
+	if msg, err := gengen("-force", "example.com/tmpl@v1.1.0"); err != nil {
+		t.Fatalf("gengen: %s\n%s", err, msg)
+	}
+}
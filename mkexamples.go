@@ -0,0 +1,64 @@
+//go:build ignore
+// +build ignore
+
+// mkexamples writes examples.go, which bundles the source of the
+// examples so -selftest doesn't need to find them on disk.  Run it
+// with go generate after changing an example.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by mkexamples.go; DO NOT EDIT.\n\n")
+	buf.WriteString("package main\n\n")
+	buf.WriteString("// exampleFiles holds the source of each Go file in the examples\n")
+	buf.WriteString("// directory, by slash-separated path relative to it.\n")
+	buf.WriteString("var exampleFiles = map[string]string{\n")
+
+	err := filepath.Walk("examples", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel("examples", path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%q: %s,\n", filepath.ToSlash(rel), quote(string(src)))
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	buf.WriteString("}\n")
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("examples.go", out, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// quote returns s as a raw string literal if it can be one.
+func quote(s string) string {
+	if strings.Contains(s, "`") || strings.Contains(s, "\r") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
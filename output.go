@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// outputFile is a generated file, named relative to the output
+// directory.
+type outputFile struct {
+	name string
+	src  []byte
+}
+
+// outputWriter emits generated files to the destination selected by
+// -out-mode.
+type outputWriter interface {
+	writeFiles(files []outputFile) error
+}
+
+func newOutputWriter(mode, out string) (outputWriter, error) {
+	switch mode {
+	case "files":
+		return dirWriter{dir: out}, nil
+	case "stdout":
+		return streamWriter{w: os.Stdout}, nil
+	case "zip":
+		if !strings.HasSuffix(out, ".zip") {
+			return nil, fmt.Errorf("-out-mode zip needs -o to name a .zip file, not %q", out)
+		}
+		return zipWriter{path: out}, nil
+	}
+	return nil, fmt.Errorf("unknown -out-mode %q; use files, stdout or zip", mode)
+}
+
+// dirWriter writes each file into a directory, creating it if needed.
+// The files are written to a temporary directory inside it first and
+// then renamed into place, so a failure part way doesn't leave some
+// of them half written or out of step with the rest.
+type dirWriter struct {
+	dir string
+}
+
+func (w dirWriter) writeFiles(files []outputFile) error {
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+
+	// a dot directory is ignored by the go tool, and being on the
+	// same file system as the output lets the files be renamed
+	tempDir, err := ioutil.TempDir(w.dir, ".gengen-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, file := range files {
+		if err := writeFile(filepath.Join(tempDir, file.name), file.src); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := os.Rename(filepath.Join(tempDir, file.name), filepath.Join(w.dir, file.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamWriter concatenates the files.  When there's more than one,
+// each is preceded by a comment naming it.
+type streamWriter struct {
+	w io.Writer
+}
+
+func (w streamWriter) writeFiles(files []outputFile) error {
+	for i, file := range files {
+		if len(files) > 1 {
+			if i > 0 {
+				fmt.Fprintln(w.w)
+			}
+			fmt.Fprintf(w.w, "// file: %s\n\n", file.name)
+		}
+		if _, err := w.w.Write(file.src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipWriter stores the files in a new zip archive.
+type zipWriter struct {
+	path string
+}
+
+func (w zipWriter) writeFiles(files []outputFile) error {
+	f, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := fw.Write(file.src); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
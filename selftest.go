@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joeshaw/gengen/genlib"
+)
+
+//go:generate go run mkexamples.go
+
+// selftests are the bundled examples and the types they're generated
+// with.  Files from extra, relative to the example, are copied next to
+// the generated ones, which all go in package main.
+var selftests = []struct {
+	name  string
+	types map[string]string
+	extra []string
+}{
+	{name: "list", types: map[string]string{"T": "int"}},
+	{name: "slice", types: map[string]string{"T": "string"}},
+	{name: "deque", types: map[string]string{"T": "int"}},
+	{name: "btree", types: map[string]string{"T": "int", "U": "string"}, extra: []string{"main/main.go"}},
+}
+
+// selftest generates each example, from the source bundled in
+// exampleFiles, into a temporary module and builds it, reporting the results on stdout.  It returns false if
+// any of them failed.
+func selftest(opts genlib.Options) bool {
+	if _, err := exec.LookPath("go"); err != nil {
+		fmt.Printf("FAIL: %s\n", err)
+		return false
+	}
+
+	ok := true
+	for _, t := range selftests {
+		if err := selftestExample(opts, t.name, t.types, t.extra); err != nil {
+			fmt.Printf("%s: FAIL: %s\n", t.name, err)
+			ok = false
+		} else {
+			fmt.Printf("%s: ok\n", t.name)
+		}
+	}
+	return ok
+}
+
+func selftestExample(opts genlib.Options, name string, types map[string]string, extra []string) error {
+	dir, err := ioutil.TempDir("", "gengen-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// the template goes in a directory of its own and the generated
+	// files in a module that builds them
+	tmplDir, outDir := filepath.Join(dir, "template"), filepath.Join(dir, "out")
+	for _, d := range []string{tmplDir, outDir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	var sourceFiles []string
+	for rel, src := range exampleFiles {
+		if path.Dir(rel) != name {
+			continue
+		}
+		file := filepath.Join(tmplDir, path.Base(rel))
+		if err := writeFile(file, []byte(src)); err != nil {
+			return err
+		}
+		sourceFiles = append(sourceFiles, file)
+	}
+	if len(sourceFiles) == 0 {
+		return fmt.Errorf("no bundled source; run go generate")
+	}
+	sort.Strings(sourceFiles)
+
+	opts.PackageName = "main"
+	converted, err := opts.GeneratePackage(sourceFiles, types)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outDir, "go.mod"), []byte("module selftest\n"), 0644); err != nil {
+		return err
+	}
+	for _, file := range converted {
+		if err := writeFile(filepath.Join(outDir, filepath.Base(file.Name)), file.Source); err != nil {
+			return err
+		}
+	}
+	for _, rel := range extra {
+		src, ok := exampleFiles[path.Join(name, rel)]
+		if !ok {
+			return fmt.Errorf("no bundled %s; run go generate", path.Join(name, rel))
+		}
+		if err := writeFile(filepath.Join(outDir, path.Base(rel)), []byte(src)); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command("go", "build", "-o", os.DevNull, ".")
+	cmd.Dir = outDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build: %s\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
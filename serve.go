@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joeshaw/gengen/genlib"
+)
+
+const (
+	maxRequestSize = 1 << 20
+	requestTimeout = 10 * time.Second
+
+	// warningHeader holds each warning about a successful conversion
+	warningHeader = "Gengen-Warning"
+)
+
+// generateRequest is the JSON body of a request to the /generate
+// endpoint:
+//
+//	{"name": "list.go", "source": "package list ...", "types": {"T": "int"}}
+type generateRequest struct {
+	Name   string            `json:"name"`
+	Source string            `json:"source"`
+	Types  map[string]string `json:"types"`
+}
+
+// serve runs an HTTP server converting the source posted to /generate
+// and responding with the result.
+func serve(addr string, opts genlib.Options) error {
+	mux := http.NewServeMux()
+	mux.Handle("/generate", http.TimeoutHandler(generateHandler(opts), requestTimeout, "timed out\n"))
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: requestTimeout,
+		ReadTimeout:       requestTimeout,
+		WriteTimeout:      2 * requestTimeout,
+	}
+	return srv.ListenAndServe()
+}
+
+func generateHandler(opts genlib.Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req generateRequest
+		body := http.MaxBytesReader(w, r.Body, maxRequestSize)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		file, err := opts.GenerateFile(sourceName(req), strings.NewReader(req.Source), req.Types)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// warnings go in headers, so the body is only the source
+		warnings := file.Warnings
+		if unused := genlib.UnusedKeys([]*genlib.File{file}); len(unused) > 0 {
+			warnings = append([]string{genlib.UnusedError(req.Types, unused).Error()}, warnings...)
+		}
+		for _, warning := range warnings {
+			w.Header().Add(warningHeader, strings.Join(strings.Fields(warning), " "))
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(file.Source)
+	})
+}
+
+// sourceName returns the file name to convert a request's source as.
+func sourceName(req generateRequest) string {
+	name := filepath.Base(req.Name)
+	if !strings.HasSuffix(name, ".go") {
+		return "source.go"
+	}
+	return name
+}
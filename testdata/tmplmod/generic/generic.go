@@ -0,0 +1,5 @@
+// Package generic is a copy of gengen's, so the template module has no
+// requirements.
+package generic
+
+type T interface{}
@@ -0,0 +1,9 @@
+package tmpl
+
+import "example.com/tmpl/generic"
+
+type Stack []generic.T
+
+func (s *Stack) Push(v generic.T) {
+	*s = append(*s, v)
+}